@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/chrome"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+)
+
+// Error codes sent to the extension in the "bridge_error" envelope.  They're
+// part of the bridge's interface, so existing codes must not change.
+const (
+	// codeDiscordNotRunning means Discord instances were found, but none
+	// matched CDB_DISCORD_USER.  (While no Discord socket accepts a
+	// connection at all, the bridge answers codeDiscordPending instead.)
+	codeDiscordNotRunning = "DISCORD_NOT_RUNNING"
+
+	// codeDiscordPending means Discord isn't running yet.  The bridge keeps
+	// trying to connect in the background, so the message can be retried.
+	codeDiscordPending = "DISCORD_PENDING"
+
+	// codeDiscordClosed means Discord closed the connection, e.g. because it
+	// rejected the client ID, or quit.
+	codeDiscordClosed = "DISCORD_CLOSED"
+
+	// codeDiscordTimeout means Discord didn't answer in time.
+	codeDiscordTimeout = "DISCORD_TIMEOUT"
+
+	// codeProtocol means Discord sent something the bridge couldn't
+	// understand.
+	codeProtocol = "PROTOCOL"
+
+	// codeUntrustedSocket means the only Discord sockets found were owned by
+	// another user.
+	codeUntrustedSocket = "UNTRUSTED_SOCKET"
+
+	// codeMessageTooLarge means a message exceeded the native messaging size
+	// limits.
+	codeMessageTooLarge = "MESSAGE_TOO_LARGE"
+
+	// codeInvalidJSON means the extension sent a message that isn't JSON.
+	codeInvalidJSON = "INVALID_JSON"
+
+	// codeInvalidOrigin means the calling extension isn't allowed to use the
+	// bridge.
+	codeInvalidOrigin = "INVALID_ORIGIN"
+
+	// codeCommandNotAllowed means the calling extension isn't allowed to send
+	// the frame's Discord RPC command.
+	codeCommandNotAllowed = "COMMAND_NOT_ALLOWED"
+
+	// codeClientIdNotAllowed means the calling extension isn't allowed to
+	// handshake with the frame's Discord application ID.
+	codeClientIdNotAllowed = "CLIENT_ID_NOT_ALLOWED"
+
+	// codeInvalidHandshake means the extension sent a handshake without a
+	// supported "v" or a "client_id".
+	codeInvalidHandshake = "INVALID_HANDSHAKE"
+
+	// codeHandshakeRequired means the extension sent a command before Discord
+	// accepted a handshake.
+	codeHandshakeRequired = "HANDSHAKE_REQUIRED"
+
+	// codeInternal means the bridge failed unexpectedly.
+	codeInternal = "INTERNAL"
+)
+
+// bridgeError is sent to the extension in place of Discord's answer when the
+// bridge couldn't forward a message.
+type bridgeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// DiscordCode is the close code if Discord closed the connection.
+	DiscordCode int `json:"discord_code,omitempty"`
+}
+
+func (e *bridgeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// MarshalJSON wraps the error in the "bridge_error" envelope.
+func (e *bridgeError) MarshalJSON() ([]byte, error) {
+	// The alias type doesn't have a MarshalJSON method, so the inner error is
+	// encoded as a plain struct.
+	type fields bridgeError
+	return json.Marshal(struct {
+		BridgeError *fields `json:"bridge_error"`
+	}{(*fields)(e)})
+}
+
+// toBridgeError classifies an error from package discord or chrome.
+func toBridgeError(err error) *bridgeError {
+	var (
+		bridgeErr   *bridgeError
+		untrusted   *discord.UntrustedSocketError
+		dialErr     *discord.DialError
+		closeErr    *discord.CloseError
+		protocolErr *discord.ProtocolError
+		sizeErr     *chrome.MessageSizeError
+		code        string
+		discordCode int
+	)
+	switch {
+	case errors.As(err, &bridgeErr):
+		return bridgeErr
+	case errors.As(err, &untrusted):
+		code = codeUntrustedSocket
+	case errors.As(err, &dialErr), errors.Is(err, discord.ErrNoInstance):
+		code = codeDiscordNotRunning
+	case errors.As(err, &closeErr):
+		code, discordCode = codeDiscordClosed, closeErr.Code
+	case errors.As(err, &protocolErr):
+		code = codeProtocol
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codeDiscordTimeout
+	case errors.Is(err, discord.ErrClosed):
+		code = codeDiscordClosed
+	case errors.As(err, &sizeErr):
+		code = codeMessageTooLarge
+	case errors.Is(err, chrome.ErrInvalidJSON):
+		code = codeInvalidJSON
+	case errors.Is(err, chrome.ErrForbiddenOrigin):
+		code = codeInvalidOrigin
+	default:
+		code = codeInternal
+	}
+	return &bridgeError{Code: code, Message: err.Error(), DiscordCode: discordCode}
+}
+
+// pendingError returns the error for a message that arrived while Discord
+// isn't connected.  lastErr is the most recent error connecting, if any.
+func pendingError(lastErr error) *bridgeError {
+	// Other users' sockets won't go away by waiting, Discord won't change its
+	// mind about a handshake it rejected, and a running Discord logged in as
+	// the wrong user is unlikely to switch accounts.
+	var closeErr *discord.CloseError
+	if lastErr != nil {
+		if e := toBridgeError(lastErr); e.Code == codeUntrustedSocket ||
+			errors.As(lastErr, &closeErr) && closeErr.RejectsHandshake() ||
+			errors.Is(lastErr, discord.ErrNoInstance) {
+			return e
+		}
+	}
+
+	message := "waiting for Discord to start"
+	if lastErr != nil {
+		message = fmt.Sprintf("%s: %v", message, lastErr)
+	}
+	return &bridgeError{Code: codeDiscordPending, Message: message}
+}
+
+// encodeError returns the "bridge_error" envelope for the error.
+func encodeError(err error) []byte {
+	buf, mErr := json.Marshal(toBridgeError(err))
+	if mErr != nil {
+		return []byte(`{"bridge_error":{"code":"INTERNAL","message":"encoding error"}}`)
+	}
+	return buf
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/chrome"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+)
+
+func TestToBridgeError(t *testing.T) {
+	untrusted := &discord.UntrustedSocketError{Addr: "/tmp/discord-ipc-0", Reason: "owned by uid 0"}
+
+	tests := []struct {
+		name            string
+		err             error
+		wantCode        string
+		wantDiscordCode int
+	}{
+		{"BridgeError", fmt.Errorf("wrapped: %w", &bridgeError{Code: codeCommandNotAllowed}), codeCommandNotAllowed, 0},
+		{"Untrusted", &discord.DialError{Tried: []string{untrusted.Addr}, Err: untrusted}, codeUntrustedSocket, 0},
+		{"DialError", &discord.DialError{Tried: []string{"/tmp/discord-ipc-0"}, Err: errors.New("refused")}, codeDiscordNotRunning, 0},
+		{"NoInstance", fmt.Errorf("%w: none matched", discord.ErrNoInstance), codeDiscordNotRunning, 0},
+		{"Close", &discord.CloseError{Code: discord.CloseInvalidClientId, Message: "Invalid Client ID"}, codeDiscordClosed, 4000},
+		{"Protocol", &discord.ProtocolError{Reason: "invalid opcode"}, codeProtocol, 0},
+		{"Timeout", fmt.Errorf("request: %w", context.DeadlineExceeded), codeDiscordTimeout, 0},
+		{"Closed", discord.ErrClosed, codeDiscordClosed, 0},
+		{"TooLarge", &chrome.MessageSizeError{Direction: "request", Size: 2, Limit: 1}, codeMessageTooLarge, 0},
+		{"InvalidJSON", chrome.ErrInvalidJSON, codeInvalidJSON, 0},
+		{"Forbidden", fmt.Errorf("%w: %q", chrome.ErrForbiddenOrigin, "x"), codeInvalidOrigin, 0},
+		{"Panic", &chrome.PanicError{Value: "oops"}, codeInternal, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toBridgeError(tt.err)
+			if got.Code != tt.wantCode || got.DiscordCode != tt.wantDiscordCode {
+				t.Errorf("Wanted %s (Discord code %d), got %+v", tt.wantCode, tt.wantDiscordCode, got)
+			}
+		})
+	}
+}
+
+func TestPendingError(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastErr  error
+		wantCode string
+	}{
+		{"NotTried", nil, codeDiscordPending},
+		{"NotRunning", &discord.DialError{Tried: []string{"/tmp/discord-ipc-0"}, Err: errors.New("refused")}, codeDiscordPending},
+		{"Untrusted", &discord.UntrustedSocketError{Addr: "/tmp/discord-ipc-0"}, codeUntrustedSocket},
+		{"NoInstance", fmt.Errorf("%w: none matched", discord.ErrNoInstance), codeDiscordNotRunning},
+		{"Rejected", &discord.CloseError{Code: discord.CloseInvalidClientId}, codeDiscordClosed},
+		{"RateLimited", &discord.CloseError{Code: discord.CloseRateLimited}, codeDiscordPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pendingError(tt.lastErr); got.Code != tt.wantCode {
+				t.Errorf("Wanted %s, got %+v", tt.wantCode, got)
+			}
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	err := &bridgeError{Code: codeDiscordClosed, Message: "bye", DiscordCode: 4000}
+	want := `{"bridge_error":{"code":"DISCORD_CLOSED","message":"bye","discord_code":4000}}`
+	if got := string(encodeError(err)); got != want {
+		t.Errorf("Wanted %s, got %s", want, got)
+	}
+
+	want = `{"bridge_error":{"code":"INTERNAL","message":"oops"}}`
+	if got := string(encodeError(errors.New("oops"))); got != want {
+		t.Errorf("Wanted %s, got %s", want, got)
+	}
+}
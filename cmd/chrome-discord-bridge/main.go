@@ -3,11 +3,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 import (
@@ -18,7 +25,10 @@ import (
 
 const usageMessage = `Usage:
 
-    chrome-discord-bridge -install
+    chrome-discord-bridge -install [-backup] [-browser BROWSER[,BROWSER...]|all]
+    chrome-discord-bridge -check [-browser BROWSER[,BROWSER...]|all]
+    chrome-discord-bridge -uninstall
+    chrome-discord-bridge -status
     chrome-discord-bridge ORIGIN
 `
 
@@ -30,20 +40,36 @@ const (
 	exitSuccess      = 0
 	exitInvalidUsage = 1
 	exitFailure      = 2
+
+	// exitDrift means -check found differences in the installed manifests.
+	exitDrift = 3
 )
 
 func main() {
-	install := flag.Bool("install", false, "Install Chrome manifest for current user")
+	install := flag.Bool("install", false, "Install browser manifests for current user")
+	browser := flag.String("browser", "", "Browsers to install or check for, comma-separated, or \"all\" for every detected browser (default chrome, and firefox if configured)")
+
+	backup := flag.Bool("backup", false, "With -install, keep a .bak copy of each manifest that is replaced")
+	check := flag.Bool("check", false, "Check that the installed browser manifests are up to date, without changing them")
+	uninstall := flag.Bool("uninstall", false, "Remove browser manifests for current user")
+	status := flag.Bool("status", false, "Show where the host is installed")
 
 	flag.Usage = usage
 	flag.Parse()
-	if *install {
+	if *install || *check || *uninstall || *status {
 		if flag.NArg() > 0 {
-			fmt.Fprintf(os.Stderr, "No arguments expected with -install, got %d\n", flag.NArg())
+			fmt.Fprintf(os.Stderr, "No arguments expected with -install, -check, -uninstall or -status, got %d\n", flag.NArg())
 			os.Exit(exitInvalidUsage)
 		}
-		runInstall()
-	} else {
+	}
+	switch {
+	case *install || *check:
+		runInstall(*browser, *check, *backup)
+	case *uninstall:
+		runUninstall()
+	case *status:
+		runStatus()
+	default:
 		serveChrome()
 	}
 }
@@ -54,7 +80,56 @@ const name = "io.github.p00ya.cdb"
 // description is used to register chrome-discord-bridge with Chrome.
 const description = `Chrome/Discord bridge - see https://github.com/p00ya/chrome-discord-bridge`
 
-func runInstall() {
+// browsersFor returns the browsers to install manifests for.  The default is
+// Chrome, plus Firefox if origins.txt lists any add-ons.
+func browsersFor(list string) ([]install.Browser, error) {
+	if list == "" {
+		browsers := []install.Browser{install.Chrome}
+		if len(uniqueAddons()) > 0 {
+			browsers = append(browsers, install.Firefox)
+		}
+		return browsers, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return install.ParseBrowsers(list, homeDir)
+}
+
+// wantedManifest returns the manifest that should be installed for the
+// browser, or false if origins.txt allows nothing for it.
+func wantedManifest(b install.Browser, absPath string) (install.Manifest, bool) {
+	m := install.Manifest{
+		Name:        name,
+		Description: description,
+		Path:        absPath,
+	}
+	if b.Firefox {
+		m.AllowedExtensions = uniqueAddons()
+		return m, len(m.AllowedExtensions) > 0
+	}
+	m.AllowedOrigins = uniqueOrigins()
+	return m, len(m.AllowedOrigins) > 0
+}
+
+// runInstall installs the manifests for the given browsers, if they differ
+// from what's installed.  If check is true, it only reports the differences,
+// and exits with exitDrift if there are any.  If backup is true, replaced
+// manifests are backed up.
+func runInstall(browserList string, check, backup bool) {
+	browsers, err := browsersFor(browserList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitInvalidUsage)
+	}
+
+	var opts []install.Option
+	if backup {
+		opts = append(opts, install.WithBackup())
+	}
+
 	binary := os.Args[0]
 	absPath, err := filepath.Abs(binary)
 	if err != nil {
@@ -62,56 +137,292 @@ func runInstall() {
 		os.Exit(exitFailure)
 	}
 
-	m := install.Manifest{
-		Name:           name,
-		Description:    description,
-		Path:           absPath,
-		AllowedOrigins: uniqueOrigins(),
+	failed, drifted := false, false
+	for _, b := range browsers {
+		m, ok := wantedManifest(b, absPath)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping %s: no allowed callers for it in origins.txt\n", b.Name)
+			continue
+		}
+
+		drift, err := b.CheckCurrentUser(m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking %s manifest: %v\n", b.Name, err)
+			failed = true
+			continue
+		}
+		if len(drift) == 0 {
+			fmt.Printf("%s manifest for %s is up to date\n", b.Name, name)
+			continue
+		}
+
+		drifted = true
+		fmt.Printf("%s manifest for %s differs:\n", b.Name, name)
+		for _, d := range drift {
+			fmt.Printf("    %v\n", d)
+		}
+		if check {
+			continue
+		}
+
+		if err = b.InstallCurrentUser(m, opts...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error installing for %s: %v\n", b.Name, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("Wrote %s manifest for %s\n", b.Name, name)
+	}
+
+	switch {
+	case failed:
+		os.Exit(exitFailure)
+	case check && drifted:
+		os.Exit(exitDrift)
 	}
+}
 
-	err = install.CurrentUser(m)
+func runUninstall() {
+	removed, err := install.Uninstall(name)
+	for _, r := range removed {
+		fmt.Printf("Removed %s manifest %s\n", r.Browser.Name, r.ManifestPath)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(exitFailure)
 	}
+	if len(removed) == 0 {
+		fmt.Printf("No manifests for %s found\n", name)
+	}
+}
 
-	fmt.Printf("Wrote manifest for %s\n", name)
+func runStatus() {
+	regs, err := install.Status(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	printStatus(regs)
 }
 
-func serveChrome() {
-	// Length should be exactly 2 on macOS and Linux, and 3 on Windows.
-	if len(os.Args) < 2 {
-		log.Fatalf("Error: wanted origin URL argument, got %d args", len(os.Args)-1)
+// printStatus describes each registration on stdout.
+func printStatus(regs []install.Registration) {
+	if len(regs) == 0 {
+		fmt.Printf("%s is not installed\n", name)
+		return
 	}
 
-	origin := os.Args[1]
-	if !IsValidOrigin(origin) {
-		log.Fatalf("Error: invalid origin %s", origin)
+	for _, r := range regs {
+		fmt.Print(r.Describe())
 	}
+}
 
-	var discordClient *discord.Client
-	var err error
-	discordClient, err = discord.Dial()
-	if err != nil {
-		log.Fatalf("Error connecting to Discord socket: %v\n", err)
+// userEnv is the environment variable that selects which Discord account to
+// connect to (by user ID), if several instances of Discord are running.
+const userEnv = "CDB_DISCORD_USER"
+
+// discordSelectors returns the selectors for choosing a Discord instance.
+func discordSelectors() []discord.Selector {
+	if id := os.Getenv(userEnv); id != "" {
+		return []discord.Selector{discord.ByUser(id)}
 	}
-	go discordClient.Start()
-	defer discordClient.Close()
+	return nil
+}
 
-	host := chrome.NewHost(os.Stdin, os.Stdout)
-	go host.Start()
-	defer host.Close()
+// connectWait is how long a message from Chrome waits for Discord to be
+// connected, before it's answered with DISCORD_PENDING.
+const connectWait = 2 * time.Second
 
-	for {
-		req, responder := host.Receive()
-		if req == nil {
-			// Clean exit - Chrome destroyed native messaging port.
-			break
-		}
-		res, err := discordClient.Send(req)
+// requestTimeout is how long to wait for Discord to answer a message from
+// Chrome, including any time spent reconnecting to Discord.
+const requestTimeout = 30 * time.Second
+
+// callerOf returns the identity of the extension that started the bridge: its
+// origin for Chromium, or its add-on ID for Firefox.
+func callerOf(inv *chrome.Invocation) string {
+	if inv.Browser == chrome.Firefox {
+		return inv.ExtensionID
+	}
+	return inv.Origin
+}
+
+// isAllowedCaller returns true if the caller is listed in origins.txt.
+func isAllowedCaller(caller string) bool {
+	return IsValidOrigin(caller) || IsValidAddon(caller)
+}
+
+// frameFields are the fields of a frame from the extension that the bridge's
+// policy depends on.
+type frameFields struct {
+	// Cmd is the Discord RPC command, or handshakeCmd for handshakes.
+	Cmd string
+
+	// ClientId is the Discord application ID, if the frame has one.
+	ClientId    string
+	HasClientId bool
+
+	// Version is the JSON encoding of the handshake's RPC version, or empty.
+	Version string
+}
+
+// frameObject decodes the top-level fields of a frame from the extension.
+//
+// Discord matches keys exactly, but encoding/json matches struct fields
+// case-insensitively (and the last match wins), so frames are decoded into a
+// map and read by exact key instead.  Keys that only differ by case are
+// rejected, so that there's no doubt which one Discord will read.
+func frameObject(req []byte) (map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(req))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("frame is not a JSON object")
+	}
+
+	fields := make(map[string]json.RawMessage)
+	seen := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
 		if err != nil {
-			log.Fatalf("Error receiving from Discord: %v\n", err)
+			return nil, err
+		}
+		key := tok.(string)
+		folded := strings.ToLower(key)
+		if seen[folded] {
+			return nil, fmt.Errorf("frame has duplicate key %q", key)
+		}
+		seen[folded] = true
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("frame has data after the JSON object")
+	}
+	return fields, nil
+}
+
+// parseFrame decodes the fields of a frame from the extension.
+func parseFrame(req []byte) (frameFields, error) {
+	fields, err := frameObject(req)
+	if err != nil {
+		return frameFields{}, err
+	}
+
+	f := frameFields{Cmd: handshakeCmd, Version: string(fields["v"])}
+	if cmd, ok := fields["cmd"]; ok && string(cmd) != "null" {
+		if err := json.Unmarshal(cmd, &f.Cmd); err != nil {
+			return frameFields{}, errors.New(`frame's "cmd" is not a string`)
 		}
-		responder.Respond(res)
+	}
+	if id, ok := fields["client_id"]; ok && string(id) != "null" {
+		f.HasClientId = true
+		// Tolerate numeric IDs, as Discord does.
+		if err := json.Unmarshal(id, &f.ClientId); err != nil {
+			f.ClientId = string(id)
+		}
+	}
+	return f, nil
+}
+
+// enforcePolicy rejects frames that the caller's policy doesn't allow: those
+// with commands not in its allowlist, so that a compromised extension can't
+// use the rest of Discord's RPC, and handshakes with client IDs not in its
+// allowlist, so that it can't impersonate other applications.
+func enforcePolicy() chrome.Middleware {
+	return func(next chrome.Handler) chrome.Handler {
+		return chrome.HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			caller := chrome.OriginFromContext(ctx)
+			p, ok := policyFor(caller)
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", chrome.ErrForbiddenOrigin, caller)
+			}
+
+			f, err := parseFrame(req)
+			if err != nil {
+				return nil, &bridgeError{Code: codeInvalidJSON, Message: err.Error()}
+			}
+			if !p.allowsCmd(f.Cmd) {
+				return nil, &bridgeError{
+					Code:    codeCommandNotAllowed,
+					Message: fmt.Sprintf("%s is not allowed for %s", f.Cmd, caller),
+				}
+			}
+			// Discord reads the client ID from whichever frame is sent as the
+			// handshake, so check every frame that has one, as well as
+			// handshakes that don't.
+			if (f.Cmd == handshakeCmd || f.HasClientId) && !p.allowsClientId(f.ClientId) {
+				return nil, &bridgeError{
+					Code:    codeClientIdNotAllowed,
+					Message: fmt.Sprintf("client ID %q is not allowed for %s", f.ClientId, caller),
+				}
+			}
+			return next.ServeMessage(ctx, req)
+		})
+	}
+}
+
+// serveChrome forwards messages from the extension to Discord until Chrome
+// closes the port.  Failures are answered with a "bridge_error" envelope
+// (see errors.go) rather than exiting, so that the extension can tell the user
+// what went wrong.
+func serveChrome() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var caller string
+	if inv, err := chrome.ParseInvocation(os.Args[1:]); err != nil {
+		log.Printf("Error: %v\n", err)
+	} else {
+		caller = callerOf(inv)
+	}
+	ctx = chrome.WithOrigin(ctx, caller)
+
+	// Reconnect transparently if Discord restarts, replaying the handshake and
+	// activity so that the extension doesn't notice.  Never give up, since
+	// Discord may not have started yet.
+	discordClient := discord.NewReconnectingClient(discord.ReconnectOptions{
+		Deadline: -1,
+		Dial: func(ctx context.Context) (*discord.Client, error) {
+			return discord.DialContext(ctx, discordSelectors()...)
+		},
+	})
+	defer discordClient.Close()
+
+	if !isAllowedCaller(caller) {
+		log.Printf("Error: invalid origin %q\n", caller)
+	}
+
+	// Connect to Discord on the first message, in the background, rather than
+	// before accepting the port.
+	sess := &session{
+		client: discordClient,
+		start: func() {
+			go func() {
+				if err := discordClient.StartContext(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Error connecting to Discord socket: %v\n", err)
+				}
+			}()
+		},
+	}
+
+	// Invalid JSON would only make Discord drop the connection, so answer it
+	// here instead.
+	handler := chrome.Chain(sess,
+		chrome.Recover(),
+		chrome.RequireOrigin(isAllowedCaller),
+		chrome.ValidateJSON(),
+		enforcePolicy())
+
+	// Serve returns cleanly when Chrome destroys the native messaging port.
+	host := chrome.NewHostWithOptions(os.Stdin, os.Stdout, chrome.HostOptions{
+		EncodeError: encodeError,
+	})
+	if err := host.ServeContext(ctx, handler); err != nil {
+		log.Printf("Error serving Chrome: %v\n", err)
 	}
 }
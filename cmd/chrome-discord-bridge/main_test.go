@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/chrome"
+)
+
+// testOrigin is added to origins by withPolicy.
+const testOrigin = "chrome-extension://abcdefghijklmnopabcdefghijklmnop/"
+
+// withPolicy allows testOrigin with the given policy for the rest of the
+// test.
+func withPolicy(t *testing.T, p policy) {
+	origins[testOrigin] = p
+	t.Cleanup(func() {
+		delete(origins, testOrigin)
+	})
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		want    frameFields
+		wantErr bool
+	}{
+		{name: "Handshake", frame: `{"v":1,"client_id":"1"}`, want: frameFields{Cmd: "HANDSHAKE", ClientId: "1", HasClientId: true, Version: "1"}},
+		{name: "Cmd", frame: `{"cmd":"SET_ACTIVITY","nonce":"1"}`, want: frameFields{Cmd: "SET_ACTIVITY"}},
+		{name: "NullCmd", frame: `{"cmd":null}`, want: frameFields{Cmd: "HANDSHAKE"}},
+		{name: "OtherKeyCase", frame: `{"CMD":"AUTHORIZE"}`, want: frameFields{Cmd: "HANDSHAKE"}},
+		{name: "CaseVariantCmd", frame: `{"cmd":"AUTHORIZE","CMD":"SET_ACTIVITY"}`, wantErr: true},
+		{name: "DuplicateCmd", frame: `{"cmd":"SET_ACTIVITY","cmd":"AUTHORIZE"}`, wantErr: true},
+		{name: "NumericCmd", frame: `{"cmd":1}`, wantErr: true},
+		{name: "NotObject", frame: `["cmd"]`, wantErr: true},
+		{name: "NumericClientId", frame: `{"v":1,"client_id":123}`, want: frameFields{Cmd: "HANDSHAKE", ClientId: "123", HasClientId: true, Version: "1"}},
+		{name: "OtherClientIdCase", frame: `{"v":1,"Client_ID":"1"}`, want: frameFields{Cmd: "HANDSHAKE", Version: "1"}},
+		{name: "CaseVariantClientId", frame: `{"v":1,"client_id":"2","Client_ID":"1"}`, wantErr: true},
+		{name: "TrailingData", frame: `{"cmd":"SET_ACTIVITY"}{"cmd":"AUTHORIZE"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFrame([]byte(tt.frame))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Wanted %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	withPolicy(t, policy{cmds: set("HANDSHAKE", "SET_ACTIVITY"), clientIds: set("1")})
+
+	forwarded := errors.New("forwarded")
+	h := chrome.Chain(chrome.HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		return nil, forwarded
+	}), enforcePolicy())
+
+	tests := []struct {
+		name     string
+		caller   string
+		frame    string
+		wantCode string // empty if the frame should be forwarded
+	}{
+		{"Handshake", testOrigin, `{"v":1,"client_id":"1"}`, ""},
+		{"Allowed", testOrigin, `{"cmd":"SET_ACTIVITY","nonce":"1"}`, ""},
+		{"NotAllowed", testOrigin, `{"cmd":"AUTHORIZE","nonce":"1"}`, codeCommandNotAllowed},
+		{"CaseVariant", testOrigin, `{"cmd":"AUTHORIZE","CMD":"SET_ACTIVITY"}`, codeInvalidJSON},
+		{"CaseVariantReversed", testOrigin, `{"CMD":"SET_ACTIVITY","cmd":"AUTHORIZE"}`, codeInvalidJSON},
+		{"ClientIdNotAllowed", testOrigin, `{"v":1,"client_id":"2"}`, codeClientIdNotAllowed},
+		{"MissingClientId", testOrigin, `{"v":1}`, codeClientIdNotAllowed},
+		{"FrameClientIdNotAllowed", testOrigin, `{"cmd":"SET_ACTIVITY","client_id":"2"}`, codeClientIdNotAllowed},
+		{"CaseVariantClientId", testOrigin, `{"v":1,"client_id":"2","Client_ID":"1"}`, codeInvalidJSON},
+		{"NotObject", testOrigin, `"SET_ACTIVITY"`, codeInvalidJSON},
+		{"Forbidden", "chrome-extension://pppppppppppppppppppppppppppppppp/", `{"v":1,"client_id":"1"}`, codeInvalidOrigin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := chrome.WithOrigin(context.Background(), tt.caller)
+			_, err := h.ServeMessage(ctx, []byte(tt.frame))
+			if tt.wantCode == "" {
+				if !errors.Is(err, forwarded) {
+					t.Errorf("Wanted frame to be forwarded, got %v", err)
+				}
+				return
+			}
+			if got := toBridgeError(err).Code; got != tt.wantCode {
+				t.Errorf("Wanted %s, got %s (%v)", tt.wantCode, got, err)
+			}
+		})
+	}
+}
@@ -2,41 +2,203 @@ package main
 
 import (
 	_ "embed"
+	"fmt"
 	"strings"
 )
 
-// originsDelimited is the newline-delimited set of URLs that are allowed to
-// call the native messaging host.  It is initialized from the contents of
-// "origins.txt".
+// originsDelimited is the newline-delimited set of URLs (for Chrome) and
+// add-on IDs (for Firefox) that are allowed to call the native messaging
+// host.  It is initialized from the contents of "origins.txt".
+//
+// Each line may be followed by space-separated KEY=VALUE options; see
+// parsePolicy.
 //go:embed origins.txt
 var originsDelimited string
 
-// origins is a set of URLs that are allowed to call the native messaging host.
-var origins = make(map[string]struct{})
+// chromeOriginPrefix distinguishes Chrome origins from Firefox add-on IDs in
+// origins.txt.
+const chromeOriginPrefix = "chrome-extension://"
+
+// handshakeCmd is the pseudo-command for handshake frames, which have no
+// "cmd" field.
+const handshakeCmd = "HANDSHAKE"
+
+// defaultCmds are the commands allowed for callers with no "cmds" option.
+var defaultCmds = []string{handshakeCmd, "SET_ACTIVITY"}
+
+// policy describes what an allowed caller may do.
+type policy struct {
+	// cmds is the set of Discord RPC commands the caller may send.
+	cmds map[string]struct{}
+
+	// clientIds is the set of Discord application IDs the caller may
+	// handshake with, or nil if any are allowed.
+	clientIds map[string]struct{}
+}
+
+// allowsCmd returns true if the caller may send the command.
+func (p policy) allowsCmd(cmd string) bool {
+	_, ok := p.cmds[cmd]
+	return ok
+}
+
+// allowsClientId returns true if the caller may handshake with the
+// application ID.
+func (p policy) allowsClientId(id string) bool {
+	if p.clientIds == nil {
+		return true
+	}
+	_, ok := p.clientIds[id]
+	return ok
+}
+
+// origins maps URLs that are allowed to call the native messaging host to
+// their policies.
+var origins = make(map[string]policy)
+
+// addon is a Firefox add-on that is allowed to call the native messaging
+// host.
+type addon struct {
+	// id is the add-on ID as written in origins.txt, for the manifest.
+	id string
+
+	policy
+}
+
+// addons maps the keys (see addonKey) of Firefox add-on IDs that are allowed
+// to call the native messaging host to the add-ons.
+var addons = make(map[string]addon)
 
 func uniqueOrigins() []string {
-	keys := make([]string, len(origins))
+	return keys(origins)
+}
+
+func uniqueAddons() []string {
+	ids := make([]string, 0, len(addons))
+	for _, a := range addons {
+		ids = append(ids, a.id)
+	}
+	return ids
+}
+
+func keys(set map[string]policy) []string {
+	keys := make([]string, len(set))
 
 	i := 0
-	for k := range origins {
+	for k := range set {
 		keys[i] = k
 		i++
 	}
 	return keys
 }
 
-// IsValidOrigin returns true if s matches a line in origins.txt.
+// IsValidOrigin returns true if s matches a Chrome origin line in origins.txt.
 func IsValidOrigin(s string) bool {
 	_, ok := origins[s]
 	return ok
 }
 
-func init() {
-	for _, s := range strings.Split(originsDelimited, "\n") {
-		trimmed := strings.TrimSpace(s)
-		if trimmed == "" {
+// IsValidAddon returns true if s matches a Firefox add-on ID line in
+// origins.txt.
+func IsValidAddon(s string) bool {
+	_, ok := addons[addonKey(s)]
+	return ok
+}
+
+// addonKey returns the key for comparing add-on IDs.  UUID-style add-on IDs
+// are case-insensitive, so they are lowercased, matching the normalization in
+// chrome.ParseInvocation.
+func addonKey(id string) string {
+	if strings.HasPrefix(id, "{") {
+		return strings.ToLower(id)
+	}
+	return id
+}
+
+// policyFor returns the policy for the caller (an origin or add-on ID), and
+// false if the caller isn't allowed at all.
+func policyFor(caller string) (policy, bool) {
+	if p, ok := origins[caller]; ok {
+		return p, true
+	}
+	a, ok := addons[addonKey(caller)]
+	return a.policy, ok
+}
+
+// parsePolicy parses the options after an origin in origins.txt:
+//
+//   - "cmds=CMD,CMD..." lists the Discord RPC commands the origin may send
+//     (HANDSHAKE for the handshake).  It defaults to defaultCmds.
+//   - "client_ids=ID,ID..." lists the Discord application IDs the origin may
+//     handshake with.  By default, any are allowed.
+func parsePolicy(options []string) (policy, error) {
+	p := policy{cmds: make(map[string]struct{})}
+	cmds := defaultCmds
+	for _, option := range options {
+		key, value, ok := cut(option, "=")
+		if !ok {
+			return policy{}, fmt.Errorf("wanted KEY=VALUE, got %q", option)
+		}
+		switch key {
+		case "cmds":
+			cmds = strings.Split(value, ",")
+		case "client_ids":
+			p.clientIds = make(map[string]struct{})
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					p.clientIds[id] = struct{}{}
+				}
+			}
+		default:
+			return policy{}, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	for _, cmd := range cmds {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			p.cmds[strings.ToUpper(cmd)] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+// cut is strings.Cut, which isn't available in Go 1.17.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// parseOrigins parses the contents of origins.txt into maps of Chrome
+// origins to policies, and of add-on keys to add-ons.
+func parseOrigins(delimited string) (map[string]policy, map[string]addon, error) {
+	origins := make(map[string]policy)
+	addons := make(map[string]addon)
+	for n, s := range strings.Split(delimited, "\n") {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
 			continue
 		}
-		origins[trimmed] = struct{}{}
+
+		p, err := parsePolicy(fields[1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		id := fields[0]
+		if strings.HasPrefix(id, chromeOriginPrefix) {
+			origins[id] = p
+		} else {
+			addons[addonKey(id)] = addon{id: id, policy: p}
+		}
+	}
+	return origins, addons, nil
+}
+
+func init() {
+	var err error
+	origins, addons, err = parseOrigins(originsDelimited)
+	if err != nil {
+		panic(fmt.Sprintf("origins.txt: %v", err))
 	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// set returns a set of the given strings.
+func set(ss ...string) map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, s := range ss {
+		m[s] = struct{}{}
+	}
+	return m
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    policy
+		wantErr bool
+	}{
+		{name: "Default", options: nil, want: policy{cmds: set("HANDSHAKE", "SET_ACTIVITY")}},
+		{
+			name:    "Cmds",
+			options: []string{"cmds=HANDSHAKE,set_activity, SUBSCRIBE ,"},
+			want:    policy{cmds: set("HANDSHAKE", "SET_ACTIVITY", "SUBSCRIBE")},
+		},
+		{name: "EmptyCmds", options: []string{"cmds="}, want: policy{cmds: set()}},
+		{
+			name:    "ClientIds",
+			options: []string{"client_ids=1, 2,"},
+			want:    policy{cmds: set("HANDSHAKE", "SET_ACTIVITY"), clientIds: set("1", "2")},
+		},
+		{name: "EmptyClientIds", options: []string{"client_ids="}, want: policy{cmds: set("HANDSHAKE", "SET_ACTIVITY"), clientIds: set()}},
+		{name: "NotKeyValue", options: []string{"cmds"}, wantErr: true},
+		{name: "UnknownOption", options: []string{"commands=HANDSHAKE"}, wantErr: true},
+		{name: "CaseSensitiveKey", options: []string{"CMDS=HANDSHAKE"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePolicy(tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wanted %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseOrigins(t *testing.T) {
+	const delimited = `
+chrome-extension://nglhipbdoknhpejdpceibmeaohidgcod/
+host@example.com cmds=HANDSHAKE
+{A1B2C3D4-0000-4000-8000-00000000000F}
+`
+	gotOrigins, gotAddons, err := parseOrigins(delimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOrigins := map[string]policy{
+		"chrome-extension://nglhipbdoknhpejdpceibmeaohidgcod/": {cmds: set("HANDSHAKE", "SET_ACTIVITY")},
+	}
+	if !reflect.DeepEqual(gotOrigins, wantOrigins) {
+		t.Errorf("Wanted origins %+v, got %+v", wantOrigins, gotOrigins)
+	}
+	wantAddons := map[string]addon{
+		"host@example.com": {id: "host@example.com", policy: policy{cmds: set("HANDSHAKE")}},
+		"{a1b2c3d4-0000-4000-8000-00000000000f}": {
+			id:     "{A1B2C3D4-0000-4000-8000-00000000000F}",
+			policy: policy{cmds: set("HANDSHAKE", "SET_ACTIVITY")},
+		},
+	}
+	if !reflect.DeepEqual(gotAddons, wantAddons) {
+		t.Errorf("Wanted add-ons %+v, got %+v", wantAddons, gotAddons)
+	}
+
+	if _, _, err = parseOrigins("host@example.com cmds"); err == nil {
+		t.Error("Wanted error for invalid options")
+	}
+}
+
+func TestAddons(t *testing.T) {
+	old := addons
+	t.Cleanup(func() {
+		addons = old
+	})
+	var err error
+	_, addons, err = parseOrigins("host@example.com\n{A1B2C3D4-0000-4000-8000-00000000000F}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"host@example.com", true},
+		{"HOST@example.com", false},
+		{"{A1B2C3D4-0000-4000-8000-00000000000F}", true},
+		{"{a1b2c3d4-0000-4000-8000-00000000000f}", true},
+		{"{a1b2c3d4-0000-4000-8000-000000000000}", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidAddon(tt.id); got != tt.want {
+			t.Errorf("Wanted IsValidAddon(%q) = %v, got %v", tt.id, tt.want, got)
+		}
+	}
+
+	// The manifest lists the IDs as written in origins.txt.
+	got := uniqueAddons()
+	sort.Strings(got)
+	want := []string{"host@example.com", "{A1B2C3D4-0000-4000-8000-00000000000F}"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wanted %q, got %q", want, got)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	const origin = "chrome-extension://nglhipbdoknhpejdpceibmeaohidgcod/"
+
+	p, ok := policyFor(origin)
+	if !ok {
+		t.Fatalf("Wanted %s from origins.txt to be allowed", origin)
+	}
+	if !p.allowsCmd("HANDSHAKE") || !p.allowsCmd("SET_ACTIVITY") || p.allowsCmd("AUTHORIZE") {
+		t.Errorf("Wanted default commands for %s, got %+v", origin, p)
+	}
+
+	for _, caller := range []string{"", "chrome-extension://aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa/", "evil@example.com"} {
+		if _, ok := policyFor(caller); ok {
+			t.Errorf("Wanted %q to be forbidden", caller)
+		}
+	}
+}
+
+func TestPolicyAllowsClientId(t *testing.T) {
+	if p := (policy{}); !p.allowsClientId("1") {
+		t.Error("Wanted any client ID to be allowed without client_ids")
+	}
+	p := policy{clientIds: set("1")}
+	if !p.allowsClientId("1") || p.allowsClientId("2") || p.allowsClientId("") {
+		t.Errorf("Wanted only client ID 1 to be allowed by %+v", p)
+	}
+}
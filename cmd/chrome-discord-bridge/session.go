@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+)
+
+// rpcVersion is the only known version of Discord's RPC protocol, sent in the
+// "v" field of handshakes.
+const rpcVersion = "1"
+
+// session forwards messages from the extension to Discord, making sure that
+// the first one is a handshake.
+//
+// discord.Client sends the first payload on a connection with the Handshake
+// opcode, whatever it contains, and Discord closes the connection if it isn't
+// a handshake.  So the session rejects frames until Discord has accepted a
+// handshake, and answers a second handshake (e.g. to switch application IDs)
+// by reconnecting.
+//
+// ServeMessage calls must not be concurrent, which chrome.Host guarantees.
+type session struct {
+	client *discord.ReconnectingClient
+
+	// start connects to Discord in the background.
+	start     func()
+	startOnce sync.Once
+
+	// handshaking is true if a handshake was sent to client, so that another
+	// handshake needs a new connection.
+	handshaking bool
+
+	// ready is true if Discord accepted the handshake.
+	ready bool
+}
+
+// ServeMessage implements chrome.Handler.
+func (s *session) ServeMessage(ctx context.Context, req []byte) ([]byte, error) {
+	f, err := parseFrame(req)
+	if err != nil {
+		return nil, &bridgeError{Code: codeInvalidJSON, Message: err.Error()}
+	}
+
+	if f.Cmd != handshakeCmd {
+		if !s.ready {
+			return nil, &bridgeError{
+				Code:    codeHandshakeRequired,
+				Message: fmt.Sprintf("%s was sent before a handshake", f.Cmd),
+			}
+		}
+		res, err := s.send(ctx, req)
+		if err != nil && !s.client.HasHandshake() {
+			// Discord rejected the handshake when it was replayed after
+			// reconnecting.
+			s.ready = false
+		}
+		return res, err
+	}
+
+	if err := validateHandshake(f); err != nil {
+		return nil, err
+	}
+	if err := s.waitConnected(ctx); err != nil {
+		return nil, err
+	}
+	if s.handshaking {
+		log.Println("Reconnecting to Discord for a new handshake")
+		s.client.Reset()
+	}
+	s.handshaking, s.ready = true, false
+
+	res, err := s.send(ctx, req)
+	s.ready = err == nil
+	return res, err
+}
+
+// validateHandshake returns an error if f isn't a handshake that Discord will
+// accept.
+func validateHandshake(f frameFields) error {
+	switch {
+	case f.Version == "":
+		return &bridgeError{Code: codeInvalidHandshake, Message: `handshake is missing "v"`}
+	case f.Version != rpcVersion:
+		return &bridgeError{
+			Code:    codeInvalidHandshake,
+			Message: fmt.Sprintf("unsupported RPC version %s, wanted %s", f.Version, rpcVersion),
+		}
+	case f.ClientId == "":
+		return &bridgeError{Code: codeInvalidHandshake, Message: `handshake is missing "client_id"`}
+	}
+	return nil
+}
+
+// waitConnected starts connecting to Discord if it hasn't already, and waits
+// up to connectWait for the connection.
+func (s *session) waitConnected(ctx context.Context) error {
+	s.startOnce.Do(s.start)
+
+	ctx, cancel := context.WithTimeout(ctx, connectWait)
+	defer cancel()
+	err := s.client.WaitConnected(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return pendingError(s.client.LastError())
+	}
+	return err
+}
+
+// send forwards the message to Discord and returns its answer.
+func (s *session) send(ctx context.Context, req []byte) ([]byte, error) {
+	if err := s.waitConnected(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	res, err := s.client.SendContext(ctx, req)
+	if err != nil {
+		log.Printf("Error forwarding message to Discord: %v\n", err)
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord/discordtest"
+)
+
+// Number of seconds to wait for things that should be near-instantaneous.
+const timeoutSeconds = 2
+
+func TestSession(t *testing.T) {
+	server, err := discordtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutSeconds*time.Second)
+	defer cancel()
+
+	client := discord.NewReconnectingClient(discord.ReconnectOptions{
+		Backoff:  discord.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Deadline: -1,
+		Dial:     server.Dial,
+	})
+	defer client.Close()
+	s := &session{
+		client: client,
+		start: func() {
+			go client.StartContext(ctx)
+		},
+	}
+
+	// Each step depends on the session's state after the previous ones.
+	tests := []struct {
+		name     string
+		frame    string
+		wantCode string // empty if Discord should answer
+		// wantSent is the opcode the frame should reach Discord with, or -1 if
+		// it shouldn't be sent.
+		wantSent int32
+	}{
+		{"FrameBeforeHandshake", `{"cmd":"SET_ACTIVITY","args":{},"nonce":"1"}`, codeHandshakeRequired, -1},
+		{"MissingVersion", `{"client_id":"1"}`, codeInvalidHandshake, -1},
+		{"WrongVersion", `{"v":2,"client_id":"1"}`, codeInvalidHandshake, -1},
+		{"StringVersion", `{"v":"1","client_id":"1"}`, codeInvalidHandshake, -1},
+		{"MissingClientId", `{"v":1}`, codeInvalidHandshake, -1},
+		{"CaseVariantClientId", `{"v":1,"client_id":"1","Client_ID":"2"}`, codeInvalidJSON, -1},
+		{"Handshake", `{"v":1,"client_id":"1"}`, "", discord.Handshake},
+		{"Frame", `{"cmd":"SET_ACTIVITY","args":{},"nonce":"2"}`, "", discord.Frame},
+		{"InvalidRehandshake", `{"v":1}`, codeInvalidHandshake, -1},
+		{"FrameAfterInvalidRehandshake", `{"cmd":"SET_ACTIVITY","args":{},"nonce":"3"}`, "", discord.Frame},
+		// A second handshake reconnects, and is sent as the handshake on the
+		// new connection, without replaying the first one.
+		{"Rehandshake", `{"v":1,"client_id":"2"}`, "", discord.Handshake},
+		{"FrameAfterRehandshake", `{"cmd":"SET_ACTIVITY","args":{},"nonce":"4"}`, "", discord.Frame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(server.Received())
+			resp, err := s.ServeMessage(ctx, []byte(tt.frame))
+			if tt.wantCode != "" {
+				if got := toBridgeError(err).Code; got != tt.wantCode {
+					t.Errorf("Wanted %s, got %s (%v)", tt.wantCode, got, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(resp) == 0 {
+				t.Error("Wanted an answer from Discord")
+			}
+
+			received := server.Received()[before:]
+			if tt.wantSent < 0 {
+				if len(received) != 0 {
+					t.Errorf("Wanted nothing sent to Discord, got %d frames", len(received))
+				}
+				return
+			}
+			if len(received) != 1 {
+				t.Fatalf("Wanted 1 frame sent to Discord, got %d", len(received))
+			}
+			if got := received[0]; got.Opcode != tt.wantSent || !bytes.Equal(got.Payload, []byte(tt.frame)) {
+				t.Errorf("Wanted %s sent with opcode %d, got %s with opcode %d", tt.frame, tt.wantSent, got.Payload, got.Opcode)
+			}
+		})
+	}
+}
+
+func TestSessionPending(t *testing.T) {
+	server, err := discordtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutSeconds*time.Second)
+	defer cancel()
+
+	// Dialling fails until started is closed, like Discord starting up after
+	// the extension.
+	started := make(chan struct{})
+	client := discord.NewReconnectingClient(discord.ReconnectOptions{
+		Backoff:  discord.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Deadline: -1,
+		Dial: func(ctx context.Context) (*discord.Client, error) {
+			select {
+			case <-started:
+				return server.Dial(ctx)
+			default:
+				return nil, errors.New("connection refused")
+			}
+		},
+	})
+	defer client.Close()
+	s := &session{
+		client: client,
+		start: func() {
+			go client.StartContext(ctx)
+		},
+	}
+
+	const handshake = `{"v":1,"client_id":"1"}`
+
+	// Wait less than connectWait, to keep the test quick.
+	pendingCtx, pendingCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer pendingCancel()
+	_, err = s.ServeMessage(pendingCtx, []byte(handshake))
+	if got := toBridgeError(err).Code; got != codeDiscordPending {
+		t.Fatalf("Wanted %s, got %s (%v)", codeDiscordPending, got, err)
+	}
+	if received := server.Received(); len(received) != 0 {
+		t.Fatalf("Wanted nothing sent to Discord, got %d frames", len(received))
+	}
+
+	close(started)
+	if _, err = s.ServeMessage(ctx, []byte(handshake)); err != nil {
+		t.Fatal(err)
+	}
+	received := server.Received()
+	if len(received) != 1 || received[0].Opcode != discord.Handshake || string(received[0].Payload) != handshake {
+		t.Errorf("Wanted %s sent as the handshake, got %+v", handshake, received)
+	}
+}
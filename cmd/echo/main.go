@@ -3,6 +3,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
 )
 
@@ -10,15 +12,12 @@ import "github.com/p00ya/chrome-discord-bridge/internal/chrome"
 
 func main() {
 	host := chrome.NewHost(os.Stdin, os.Stdout)
-	go host.Start()
-	defer host.Close()
+	echo := chrome.HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
 
-	for {
-		req, responder := host.Receive()
-		if req == nil {
-			// Clean exit - Chrome destroyed native messaging port.
-			break
-		}
-		responder.Respond(req)
+	// Serve returns cleanly when Chrome destroys the native messaging port.
+	if err := host.Serve(echo); err != nil {
+		log.Fatal(err)
 	}
 }
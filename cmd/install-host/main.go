@@ -19,7 +19,9 @@ import (
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n"+
-		"%s [-system] [-o ORIGIN]... [-d DESC] NAME BINARY\n\n", os.Args[0])
+		"%s [-browser BROWSER[,BROWSER...]|all] [-system] [-backup] [-o ORIGIN]... [-e ADDON_ID]... [-d DESC] NAME BINARY\n"+
+		"%s -uninstall [-system] NAME\n"+
+		"%s -status NAME\n\n", os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -44,25 +46,76 @@ func (ss *originList) Set(value string) error {
 	return nil
 }
 
+type addonList []string
+
+func (ss *addonList) String() string {
+	return strings.Join(*ss, ", ")
+}
+
+// Set appends the value to the set.
+func (ss *addonList) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("empty add-on ID")
+	}
+	*ss = append(*ss, value)
+	return nil
+}
+
 func validateName(name string) (ok bool) {
 	ok, _ = regexp.MatchString(`^([a-z0-9_]+)(\.[a-z0-9_]+)*$`, name)
 	return
 }
 
 func main() {
-	sys := flag.Bool("system", false, "Install system-wide (instead of for current user)")
+	sys := flag.Bool("system", false, "Install or uninstall system-wide (instead of for current user)")
+	uninstall := flag.Bool("uninstall", false, "Remove the host's manifests from every browser")
+	status := flag.Bool("status", false, "Show where the host is installed")
 	desc := flag.String("d", "", "Host description")
+	backup := flag.Bool("backup", false, "Keep a .bak copy of each manifest that is replaced")
 	var origins originList
 	flag.Var(&origins, "o", "Allowed-origin URL.  Repeat flag for multiple URLs")
+	var addons addonList
+	flag.Var(&addons, "e", "Allowed Firefox add-on ID.  Repeat flag for multiple IDs")
+	browserList := flag.String("browser", install.Chrome.Name, "Browsers to install for, comma-separated, or \"all\" for every detected browser")
 
 	flag.Usage = printUsage
 	flag.Parse()
+	if *uninstall || *status {
+		if flag.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "Error: expected 1 argument, got %d\n", flag.NArg())
+			printUsage()
+			os.Exit(exitInvalidUsage)
+		}
+		name := flag.Arg(0)
+		if !validateName(name) {
+			fmt.Fprintf(os.Stderr, "Error: invalid host name \"%s\"\n", name)
+			os.Exit(exitInvalidUsage)
+		}
+		if *uninstall {
+			runUninstall(name, *sys)
+		} else {
+			runStatus(name)
+		}
+		return
+	}
+
 	if flag.NArg() != 2 {
 		fmt.Fprintf(os.Stderr, "Error: expected 2 arguments, got %d\n", flag.NArg())
 		printUsage()
 		os.Exit(exitInvalidUsage)
 	}
 
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	browsers, err := install.ParseBrowsers(*browserList, homeDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitInvalidUsage)
+	}
+
 	name := flag.Arg(0)
 	if !validateName(name) {
 		fmt.Fprintf(os.Stderr, "Error: invalid host name \"%s\"\n", name)
@@ -81,22 +134,89 @@ func main() {
 		os.Exit(exitFailure)
 	}
 
-	m := install.Manifest{
-		Name:           name,
-		Description:    *desc,
-		Path:           absPath,
-		AllowedOrigins: origins,
+	var opts []install.Option
+	if *backup {
+		opts = append(opts, install.WithBackup())
 	}
 
-	if *sys {
-		err = install.System(m)
+	failed, wrote := false, false
+	for _, b := range browsers {
+		m := install.Manifest{
+			Name:        name,
+			Description: *desc,
+			Path:        absPath,
+		}
+		// Browsers reject manifests that don't allow any callers.
+		if b.Firefox {
+			if len(addons) == 0 {
+				fmt.Fprintf(os.Stderr, "Skipping %s: no add-on IDs given with -e\n", b.Name)
+				continue
+			}
+			m.AllowedExtensions = addons
+		} else {
+			if len(origins) == 0 {
+				fmt.Fprintf(os.Stderr, "Skipping %s: no origins given with -o\n", b.Name)
+				continue
+			}
+			m.AllowedOrigins = origins
+		}
+
+		if *sys {
+			err = b.InstallSystem(m, opts...)
+		} else {
+			err = b.InstallCurrentUser(m, opts...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error installing for %s: %v\n", b.Name, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("Wrote %s manifest for %s\n", b.Name, name)
+		wrote = true
+	}
+
+	if !wrote && !failed {
+		fmt.Fprintf(os.Stderr, "Error: no manifests written; pass -o or -e\n")
+		os.Exit(exitInvalidUsage)
+	}
+	if failed {
+		os.Exit(exitFailure)
+	}
+}
+
+func runUninstall(name string, sys bool) {
+	var removed []install.Registration
+	var err error
+	if sys {
+		removed, err = install.UninstallSystem(name)
 	} else {
-		err = install.CurrentUser(m)
+		removed, err = install.Uninstall(name)
 	}
+	for _, r := range removed {
+		fmt.Printf("Removed %s manifest %s\n", r.Browser.Name, r.ManifestPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if len(removed) == 0 {
+		fmt.Printf("No manifests for %s found\n", name)
+	}
+}
+
+func runStatus(name string) {
+	regs, err := install.Status(name)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(exitFailure)
 	}
+	if len(regs) == 0 {
+		fmt.Printf("%s is not installed\n", name)
+		return
+	}
 
-	fmt.Printf("Wrote manifest for %s\n", name)
+	for _, r := range regs {
+		fmt.Print(r.Describe())
+	}
 }
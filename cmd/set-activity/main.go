@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -11,15 +12,9 @@ import (
 	"strings"
 )
 
-import "github.com/p00ya/chrome-discord-bridge/internal/discord"
-
-const (
-	activityGameType   = 0
-	activityStreaming  = 1
-	activityListening  = 2
-	activityWatching   = 3
-	activityCustom     = 4
-	acitivityCompeting = 5
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord/rpc"
 )
 
 func printUsage() {
@@ -105,18 +100,22 @@ func main() {
 	}
 	go discordClient.Start()
 
-	var res []byte
-	if res, err = sendHandshake(discordClient, clientId); err != nil {
+	ctx := context.Background()
+	rpcClient := rpc.NewClient(printingSender{discordClient})
+
+	if _, err = rpcClient.Handshake(ctx, clientId); err != nil {
 		fmt.Fprintf(os.Stderr, "Error sending HANDSHAKE: %v\n", err)
 		os.Exit(exitFailure)
 	}
-	fmt.Println(string(res))
 
-	if res, err = sendSetActivity(discordClient, pid, activityState, *detailsFlag); err != nil {
+	_, err = rpcClient.SetActivity(ctx, pid, &rpc.Activity{
+		State:   activityState,
+		Details: *detailsFlag,
+	})
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error sending SET_ACTIVITY: %v\n", err)
 		os.Exit(exitFailure)
 	}
-	fmt.Println(string(res))
 
 	// Wait for a user interrupt before exiting.
 	sigint := make(chan os.Signal, 1)
@@ -126,3 +125,16 @@ func main() {
 	discordClient.Close()
 	os.Exit(exitSuccess)
 }
+
+// printingSender prints each of Discord's answers to stdout, as it sent them.
+type printingSender struct {
+	sender rpc.Sender
+}
+
+func (s printingSender) SendContext(ctx context.Context, payload discord.Payload) (discord.Payload, error) {
+	res, err := s.sender.SendContext(ctx, payload)
+	if err == nil {
+		fmt.Println(string(res))
+	}
+	return res, err
+}
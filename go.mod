@@ -3,6 +3,6 @@ module github.com/p00ya/chrome-discord-bridge
 go 1.17
 
 require (
-	github.com/Microsoft/go-winio v0.5.2 // indirect
-	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
+	github.com/Microsoft/go-winio v0.5.2
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
 )
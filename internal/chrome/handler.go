@@ -0,0 +1,225 @@
+package chrome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Handler responds to a message from Chrome.
+//
+// If ServeMessage returns an error, the response sent to Chrome is the error,
+// encoded by ErrorResponse (or HostOptions.EncodeError) instead.
+type Handler interface {
+	ServeMessage(ctx context.Context, req []byte) (resp []byte, err error)
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, req []byte) ([]byte, error)
+
+// ServeMessage calls f(ctx, req).
+func (f HandlerFunc) ServeMessage(ctx context.Context, req []byte) ([]byte, error) {
+	return f(ctx, req)
+}
+
+// Middleware wraps a Handler with additional behaviour.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the given middleware.  The first middleware is the
+// outermost, i.e. it sees each request first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Serve runs the host's event loop, and responds to each message from Chrome
+// with the handler, until Chrome closes the port.  It must not be combined
+// with Start() or Receive().
+func (h *Host) Serve(handler Handler) error {
+	return h.ServeContext(context.Background(), handler)
+}
+
+// ServeContext is like Serve, but passes ctx to the handler, and also stops
+// when ctx is done, in which case it returns ctx.Err().
+func (h *Host) ServeContext(ctx context.Context, handler Handler) error {
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- h.Start()
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.Close()
+		case <-h.done:
+		}
+	}()
+
+	for {
+		req, responder := h.Receive()
+		if req == nil {
+			// Chrome destroyed the native messaging port, or the host was closed.
+			break
+		}
+
+		resp, err := handler.ServeMessage(ctx, req)
+		if err != nil {
+			resp = h.encodeError(err)
+		}
+		responder.Respond(resp)
+	}
+
+	err := <-startDone
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// ErrorResponse returns the payload sent to Chrome when a Handler returns an
+// error.
+//
+// If err (or an error it wraps) implements json.Marshaler, its JSON encoding
+// is used.  Otherwise, the payload is a JSON object with the error message in
+// the "error" field.
+func ErrorResponse(err error) []byte {
+	var m json.Marshaler
+	if errors.As(err, &m) {
+		if buf, mErr := m.MarshalJSON(); mErr == nil {
+			return buf
+		}
+	}
+
+	buf, mErr := json.Marshal(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	if mErr != nil {
+		return []byte(`{"error":"internal error"}`)
+	}
+	return buf
+}
+
+// ErrInvalidJSON is returned by the ValidateJSON middleware for requests that
+// aren't valid JSON.
+var ErrInvalidJSON = errors.New("request is not valid JSON")
+
+// ErrForbiddenOrigin is returned by the RequireOrigin middleware for requests
+// from origins that aren't allowed.
+var ErrForbiddenOrigin = errors.New("origin is not allowed")
+
+// PanicError is returned by the Recover middleware when a handler panics.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Logging logs each request's size, the handler's duration, and any error.
+// It doesn't log the contents of messages.
+func Logging(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			start := time.Now()
+			resp, err := next.ServeMessage(ctx, req)
+			if err != nil {
+				logger.Printf("%d-byte request failed after %v: %v", len(req), time.Since(start), err)
+			} else {
+				logger.Printf("%d-byte request answered with %d bytes after %v", len(req), len(resp), time.Since(start))
+			}
+			return resp, err
+		})
+	}
+}
+
+// ValidateJSON rejects requests that aren't valid JSON with ErrInvalidJSON.
+// Chrome only sends JSON, so other requests indicate a bug.
+func ValidateJSON() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			if !json.Valid(req) {
+				return nil, ErrInvalidJSON
+			}
+			return next.ServeMessage(ctx, req)
+		})
+	}
+}
+
+// Recover turns panics in the handler into a *PanicError, so that one bad
+// message doesn't take down the host.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req []byte) (resp []byte, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					resp, err = nil, &PanicError{v}
+				}
+			}()
+			return next.ServeMessage(ctx, req)
+		})
+	}
+}
+
+// Timeout gives each request a deadline of d.  If the handler hasn't returned
+// by then, the response is an error wrapping context.DeadlineExceeded (and the
+// handler's eventual result is discarded).
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			type result struct {
+				resp []byte
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				resp, err := next.ServeMessage(ctx, req)
+				done <- result{resp, err}
+			}()
+
+			select {
+			case r := <-done:
+				return r.resp, r.err
+			case <-ctx.Done():
+				return nil, fmt.Errorf("request timed out after %v: %w", d, ctx.Err())
+			}
+		})
+	}
+}
+
+// originKey is the context key for the caller's origin.
+type originKey struct{}
+
+// WithOrigin returns a context carrying the origin of the extension that
+// started the host, for the RequireOrigin middleware.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// OriginFromContext returns the origin stored by WithOrigin, or the empty
+// string.
+func OriginFromContext(ctx context.Context) string {
+	origin, _ := ctx.Value(originKey{}).(string)
+	return origin
+}
+
+// RequireOrigin rejects requests with ErrForbiddenOrigin unless the origin in
+// the context (see WithOrigin) is allowed.
+func RequireOrigin(allowed func(origin string) bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			if origin := OriginFromContext(ctx); !allowed(origin) {
+				return nil, fmt.Errorf("%w: %q", ErrForbiddenOrigin, origin)
+			}
+			return next.ServeMessage(ctx, req)
+		})
+	}
+}
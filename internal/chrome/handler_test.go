@@ -0,0 +1,221 @@
+package chrome
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wire returns the payload in Chrome native messaging wire format.
+func wire(payload string) []byte {
+	buf := make([]byte, headerLen+len(payload))
+	nativeEndian.PutUint32(buf[:headerLen], uint32(len(payload)))
+	copy(buf[headerLen:], payload)
+	return buf
+}
+
+// wantWrite checks that Chrome reads the given payload next from out.
+func wantWrite(t *testing.T, out io.Reader, payload string) {
+	t.Helper()
+	want := wire(payload)
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(out, buf); err != nil {
+		t.Fatalf("Wanted %s, got %v", payload, err)
+	}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("Wanted write %q, got %q", want, buf)
+	}
+}
+
+// jsonError implements json.Marshaler, to test ErrorResponse.
+type jsonError struct{}
+
+func (jsonError) Error() string {
+	return "json error"
+}
+
+func (jsonError) MarshalJSON() ([]byte, error) {
+	return []byte(`{"code":1}`), nil
+}
+
+func TestServe(t *testing.T) {
+	in, inPipe := io.Pipe()
+	outPipe, out := io.Pipe()
+	host := NewHost(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- host.ServeContext(ctx, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			if string(req) == `"fail"` {
+				return nil, errors.New("failed")
+			}
+			return req, nil
+		}))
+	}()
+
+	// roundTrip simulates Chrome writing a request and reading the response.
+	roundTrip := func(t *testing.T, request, wantResponse string) {
+		go inPipe.Write(wire(request))
+
+		want := wire(wantResponse)
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(outPipe, buf); err != nil {
+			t.Fatalf("Wanted response %s, got %v", wantResponse, err)
+		}
+		if !bytes.Equal(buf, want) {
+			t.Errorf("Wanted write %q, got %q", want, buf)
+		}
+	}
+
+	t.Run("Echo", func(t *testing.T) {
+		roundTrip(t, `{"request":1}`, `{"request":1}`)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		roundTrip(t, `"fail"`, `{"error":"failed"}`)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		go io.Copy(io.Discard, outPipe)
+		cancel()
+		select {
+		case err := <-serveDone:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Wanted context.Canceled, got %v", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for ServeContext() to return")
+		}
+	})
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"Plain", errors.New(`bad "thing"`), `{"error":"bad \"thing\""}`},
+		{"Marshaler", jsonError{}, `{"code":1}`},
+		{"WrappedMarshaler", errWrap{jsonError{}}, `{"code":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(ErrorResponse(tt.err)); got != tt.want {
+				t.Errorf("Wanted %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// errWrap wraps an error without adding any methods.
+type errWrap struct {
+	err error
+}
+
+func (e errWrap) Error() string {
+	return "wrapped: " + e.err.Error()
+}
+
+func (e errWrap) Unwrap() error {
+	return e.err
+}
+
+func TestMiddleware(t *testing.T) {
+	echo := HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	ctx := context.Background()
+
+	t.Run("Chain", func(t *testing.T) {
+		var order []string
+		record := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+					order = append(order, name)
+					return next.ServeMessage(ctx, req)
+				})
+			}
+		}
+
+		h := Chain(echo, record("a"), record("b"))
+		if _, err := h.ServeMessage(ctx, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(order, ","); got != "a,b" {
+			t.Errorf("Wanted middleware order a,b, got %s", got)
+		}
+	})
+
+	t.Run("ValidateJSON", func(t *testing.T) {
+		h := Chain(echo, ValidateJSON())
+		if _, err := h.ServeMessage(ctx, []byte(`{"ok":true}`)); err != nil {
+			t.Errorf("Wanted valid JSON to pass, got %v", err)
+		}
+		if _, err := h.ServeMessage(ctx, []byte(`{"ok":`)); !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("Wanted ErrInvalidJSON, got %v", err)
+		}
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		h := Chain(HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			panic("oops")
+		}), Recover())
+
+		_, err := h.ServeMessage(ctx, []byte(`{}`))
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "oops" {
+			t.Errorf("Wanted *PanicError, got %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		h := Chain(HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+			<-ctx.Done()
+			return nil, nil
+		}), Timeout(time.Millisecond))
+
+		if _, err := h.ServeMessage(ctx, []byte(`{}`)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Wanted context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("RequireOrigin", func(t *testing.T) {
+		h := Chain(echo, RequireOrigin(func(origin string) bool {
+			return origin == "chrome-extension://good/"
+		}))
+
+		good := WithOrigin(ctx, "chrome-extension://good/")
+		if _, err := h.ServeMessage(good, []byte(`{}`)); err != nil {
+			t.Errorf("Wanted allowed origin to pass, got %v", err)
+		}
+		bad := WithOrigin(ctx, "chrome-extension://bad/")
+		if _, err := h.ServeMessage(bad, []byte(`{}`)); !errors.Is(err, ErrForbiddenOrigin) {
+			t.Errorf("Wanted ErrForbiddenOrigin, got %v", err)
+		}
+	})
+}
+
+func TestServeEncodeError(t *testing.T) {
+	in, inPipe := io.Pipe()
+	outPipe, out := io.Pipe()
+	host := NewHostWithOptions(in, out, HostOptions{
+		EncodeError: func(err error) []byte {
+			return []byte(`"custom"`)
+		},
+	})
+	defer host.Close()
+
+	go host.Serve(HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	}))
+	go inPipe.Write(wire(`{}`))
+	wantWrite(t, outPipe, `"custom"`)
+}
@@ -2,15 +2,22 @@
 package chrome
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
 // Host manages the I/O for a Chrome native messaging host.
 //
 // Create a new Host with NewHost().  Run Start() to start the event loop.
 // Read and respond to messages from Chrome, one at a time, with Receive().
-// Terminate the connection with Close().
+// Send unsolicited messages to Chrome at any time with Send().  Terminate the
+// connection with Close().
+//
+// Alternatively, Serve() runs the event loop and answers each message with a
+// Handler.
 type Host struct {
 	// in receives payloads that were received from Chrome.
 	// Only written to by Start(), only read by Receive().
@@ -20,9 +27,17 @@ type Host struct {
 	// Only written to by Respond(), only read by Start().
 	out chan []byte
 
+	// push receives unsolicited payloads that should be sent to Chrome.
+	// Only written to by Send(), only read by Start().
+	push chan pushRequest
+
 	// closed receives a value when the connection to Chrome should be shut down.
 	// Only written to by Close(), only read by Start().
-	closed chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// done is closed when Start() returns.
+	done chan struct{}
 
 	// inFile is the file for reading from Chrome (typically stdin).
 	// Only read by (an anonymous goroutine spawned by) Start().
@@ -31,78 +46,228 @@ type Host struct {
 	// outFile is the file for writing to Chrome (typically stdout).
 	// Only written to by Start().
 	writer io.WriteCloser
+
+	// maxRequest and maxResponse are the maximum payload lengths in each
+	// direction.
+	maxRequest, maxResponse int
+
+	// encodeError encodes errors for sending to Chrome.
+	encodeError func(err error) []byte
 }
 
 // headerLen is the number of bytes in the Chrome native messaging header.
 const headerLen = 4
 
-// maxPayloadBytes in the maximum length in bytes of a message from Chrome (not
-// including the 4-byte header).
-const maxPayloadBytes = 4096
+// MaxResponseBytes is the maximum length in bytes of a message to Chrome (not
+// including the 4-byte header).  Chrome closes the port if a native messaging
+// host sends a longer message.
+const MaxResponseBytes = 1024 * 1024
+
+// MaxRequestBytes is the maximum length in bytes of a message that Chrome
+// will send to a native messaging host.
+const MaxRequestBytes = 64 * 1024 * 1024
+
+// DefaultMaxRequestBytes is the default maximum length in bytes of a message
+// from Chrome.  It's much smaller than MaxRequestBytes, but large enough for
+// any Discord activity.
+const DefaultMaxRequestBytes = 64 * 1024
+
+// HostOptions configures a Host.  Zero values select the defaults.
+type HostOptions struct {
+	// MaxRequestBytes is the maximum length of a message from Chrome.  Longer
+	// messages are discarded, and answered with a *MessageSizeError.  It
+	// defaults to DefaultMaxRequestBytes.
+	MaxRequestBytes int
+
+	// MaxResponseBytes is the maximum length of a message to Chrome.  Longer
+	// responses are replaced with a *MessageSizeError.  It defaults to (and
+	// can't exceed) MaxResponseBytes.
+	MaxResponseBytes int
+
+	// EncodeError returns the payload sent to Chrome in place of a response,
+	// for a *MessageSizeError or an error returned by a Handler.  It defaults
+	// to ErrorResponse.
+	EncodeError func(err error) []byte
+}
 
 // NewHost returns a Chrome native messaging host that will read requests from
 // the given reader, and send responses on the given writer.  The I/O must
 // not be buffered.
 func NewHost(in io.Reader, out io.WriteCloser) *Host {
+	return NewHostWithOptions(in, out, HostOptions{})
+}
+
+// NewHostWithOptions is like NewHost, but with the given options.
+func NewHostWithOptions(in io.Reader, out io.WriteCloser, opts HostOptions) *Host {
+	if opts.MaxRequestBytes <= 0 {
+		opts.MaxRequestBytes = DefaultMaxRequestBytes
+	} else if opts.MaxRequestBytes > MaxRequestBytes {
+		opts.MaxRequestBytes = MaxRequestBytes
+	}
+	if opts.MaxResponseBytes <= 0 || opts.MaxResponseBytes > MaxResponseBytes {
+		opts.MaxResponseBytes = MaxResponseBytes
+	}
+	if opts.EncodeError == nil {
+		opts.EncodeError = ErrorResponse
+	}
+
 	return &Host{
-		in:     make(chan []byte),
-		out:    make(chan []byte),
-		closed: make(chan struct{}),
-		reader: in,
-		writer: out,
+		in:          make(chan []byte),
+		out:         make(chan []byte),
+		push:        make(chan pushRequest),
+		closed:      make(chan struct{}),
+		done:        make(chan struct{}),
+		reader:      in,
+		writer:      out,
+		maxRequest:  opts.MaxRequestBytes,
+		maxResponse: opts.MaxResponseBytes,
+		encodeError: opts.EncodeError,
 	}
 }
 
+// MessageSizeError reports a message that exceeded the host's size limits.
+// It's sent to Chrome (as JSON) in place of the message.
+type MessageSizeError struct {
+	// Direction is "request" for messages from Chrome, or "response" for
+	// messages to Chrome.
+	Direction string
+
+	// Size is the length of the message, and Limit is the maximum length.
+	Size, Limit int
+}
+
+func (e *MessageSizeError) Error() string {
+	return fmt.Sprintf("%d-byte %s exceeds limit of %d bytes", e.Size, e.Direction, e.Limit)
+}
+
+// MarshalJSON encodes the error for sending to Chrome.
+func (e *MessageSizeError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error     string `json:"error"`
+		Direction string `json:"direction"`
+		Size      int    `json:"size"`
+		Limit     int    `json:"limit"`
+	}{e.Error(), e.Direction, e.Size, e.Limit})
+}
+
+// readResult is a payload read from Chrome, or an error for a message that
+// was discarded.
+type readResult struct {
+	payload []byte
+	err     *MessageSizeError
+}
+
+// pushRequest is an unsolicited payload queued by Send(), along with a channel
+// for the result of writing it.
+type pushRequest struct {
+	payload []byte
+	err     chan error
+}
+
 // Start begins listening for messages from Chrome, and will return them via
-// Read().  It then waits for a response via Send(), and will send the response
-// to Chrome.
+// Receive().  It then waits for a response via Respond(), and will send the
+// response to Chrome.  Messages queued with Send() are written to Chrome as
+// soon as possible, in between responses.
 //
 // Start returns if there was an error or if Close() was called.  It will close
 // the writer the host was created with, but not the reader.
 func (h *Host) Start() error {
-	readerCh := make(chan []byte)
+	readerCh := make(chan readResult)
 
 	// Read messages from h.reader and send them to readerCh.
 	// This goroutine has exclusive access to h.reader.  It runs until it
 	// fails to read a message from Chrome.
 	go func(r io.Reader) {
+		defer close(readerCh)
 		for {
-			buf, err := readPayload(r)
+			buf, err := readPayload(r, h.maxRequest)
+			var sizeErr *MessageSizeError
+			if errors.As(err, &sizeErr) {
+				readerCh <- readResult{err: sizeErr}
+				continue
+			}
 			if err != nil || len(buf) == 0 {
-				break
+				return
 			}
-			readerCh <- buf
+			readerCh <- readResult{payload: buf}
 		}
-		close(readerCh)
 	}(h.reader)
 
+	defer close(h.done)
 	defer close(h.in)
 	defer h.writer.Close()
 
+	// request is a message from Chrome that hasn't been passed to Receive()
+	// yet.
+	var request []byte
+	// awaiting is true between reading a message from Chrome and writing the
+	// response.
+	awaiting := false
+
 	for {
+		// Don't read more messages from Chrome until we've responded.
+		var reads <-chan readResult
+		if !awaiting {
+			reads = readerCh
+		}
+		// Only offer a request to Receive() if there is one.
+		var in chan<- []byte
+		if request != nil {
+			in = h.in
+		}
+
 		select {
-		case request, ok := <-readerCh:
+		case r, ok := <-reads:
 			if !ok {
 				// Chrome-initiated shutdown.
 				return nil
 			}
-			h.in <- request
+			if r.err != nil {
+				// Answer oversized requests without involving Receive().
+				if err := writePayload(h.encodeError(r.err), h.writer); err != nil {
+					return err
+				}
+				continue
+			}
+			request, awaiting = r.payload, true
+		case in <- request:
+			request = nil
+		case response := <-h.out:
+			if len(response) > h.maxResponse {
+				// Chrome would close the port rather than accept it.
+				response = h.encodeError(h.responseSizeError(response))
+			}
+			if err := writePayload(response, h.writer); err != nil {
+				return err
+			}
+			awaiting = false
+		case p := <-h.push:
+			if len(p.payload) > h.maxResponse {
+				p.err <- h.responseSizeError(p.payload)
+				continue
+			}
+			err := writePayload(p.payload, h.writer)
+			p.err <- err
+			if err != nil {
+				return err
+			}
 		case <-h.closed:
 			// Client-initiated shutdown.
 			return nil
 		}
-
-		// Don't read more messages from Chrome until we've responded.
-		response := <-h.out
-		if err := writePayload(response, h.writer); err != nil {
-			return err
-		}
 	}
 }
 
+// responseSizeError returns the error for a payload that's too long to send to
+// Chrome.
+func (h *Host) responseSizeError(payload []byte) *MessageSizeError {
+	return &MessageSizeError{Direction: "response", Size: len(payload), Limit: h.maxResponse}
+}
+
 // readPayload returns a Chrome native messaging payload read from the given
-// file.
-func readPayload(in io.Reader) ([]byte, error) {
+// file.  If the payload is longer than maxPayload bytes, it is discarded and
+// readPayload returns a *MessageSizeError.
+func readPayload(in io.Reader, maxPayload int) ([]byte, error) {
 	header := make([]byte, headerLen)
 	switch n, err := in.Read(header); {
 	case n == 0 || err == io.EOF:
@@ -116,8 +281,12 @@ func readPayload(in io.Reader) ([]byte, error) {
 	}
 
 	payloadLen := nativeEndian.Uint32(header)
-	if payloadLen > maxPayloadBytes {
-		return nil, fmt.Errorf("want at most %d-byte payload, got %d", maxPayloadBytes, payloadLen)
+	if int64(payloadLen) > int64(maxPayload) {
+		// Skip the payload so that the next message can be read.
+		if _, err := io.CopyN(io.Discard, in, int64(payloadLen)); err != nil {
+			return nil, err
+		}
+		return nil, &MessageSizeError{Direction: "request", Size: int(payloadLen), Limit: maxPayload}
 	}
 
 	payload := make([]byte, payloadLen)
@@ -148,12 +317,19 @@ func writePayload(payload []byte, out io.Writer) error {
 type Responder struct {
 	// response receives a payload for sending to Chrome.
 	response chan []byte
+
+	// done is closed when the host's Start() returns.
+	done chan struct{}
 }
 
 // Respond sends the given response payload to Chrome.  Must be called exactly
-// once.
+// once.  The response is dropped if the host has shut down, and replaced with
+// a *MessageSizeError if it's longer than the host's response limit.
 func (r Responder) Respond(response []byte) {
-	r.response <- response
+	select {
+	case r.response <- response:
+	case <-r.done:
+	}
 }
 
 // Receive blocks on receiving one message from Chrome, and then returns the
@@ -162,12 +338,31 @@ func (r Responder) Respond(response []byte) {
 // again), which will forward the response to Chrome.
 func (h *Host) Receive() (request []byte, responder *Responder) {
 	request = <-h.in
-	responder = &Responder{response: h.out}
+	responder = &Responder{response: h.out, done: h.done}
 	return
 }
 
+// Send sends an unsolicited message to Chrome.  It may be called concurrently
+// with Receive() and Respond(), and from multiple goroutines; the message will
+// be written in between responses.
+//
+// Send blocks until the message has been written, and returns an error if it
+// couldn't be, e.g. because the host has shut down.  Messages longer than the
+// host's response limit aren't sent; Send returns a *MessageSizeError.
+func (h *Host) Send(payload []byte) error {
+	p := pushRequest{payload: payload, err: make(chan error, 1)}
+	select {
+	case h.push <- p:
+		return <-p.err
+	case <-h.done:
+		return fmt.Errorf("native messaging host has shut down")
+	}
+}
+
 // Close terminates the event loop and indicates that no more messages will
-// be processed.
+// be processed.  It may be called more than once.
 func (h *Host) Close() {
-	close(h.closed)
+	h.closeOnce.Do(func() {
+		close(h.closed)
+	})
 }
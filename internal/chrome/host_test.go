@@ -2,6 +2,7 @@ package chrome
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 	"time"
@@ -148,3 +149,112 @@ func TestHost(t *testing.T) {
 		}
 	})
 }
+
+func TestHostSend(t *testing.T) {
+	in, inPipe := io.Pipe()
+	outPipe, out := io.Pipe()
+	host := NewHost(in, out)
+
+	startDone := make(chan error)
+	go func() {
+		startDone <- host.Start()
+	}()
+
+	// send calls Send() in a new goroutine.
+	send := func(payload string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- host.Send([]byte(payload))
+		}()
+		return done
+	}
+
+	wait := func(t *testing.T, done chan error) {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Send()")
+		}
+	}
+
+	t.Run("Idle", func(t *testing.T) {
+		done := send(`{"event":1}`)
+		wantWrite(t, outPipe, `{"event":1}`)
+		wait(t, done)
+	})
+
+	t.Run("Interleaved", func(t *testing.T) {
+		go inPipe.Write(wire(`{"request":1}`))
+		request, responder := host.Receive()
+		if string(request) != `{"request":1}` {
+			t.Errorf("Got unexpected request %s", request)
+		}
+
+		// Send while the request is waiting on a response.
+		done := send(`{"event":2}`)
+		wantWrite(t, outPipe, `{"event":2}`)
+		wait(t, done)
+
+		go responder.Respond([]byte(`{"response":1}`))
+		wantWrite(t, outPipe, `{"response":1}`)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		go io.Copy(io.Discard, outPipe)
+		host.Close()
+		select {
+		case err := <-startDone:
+			if err != nil {
+				t.Errorf("Start() returned error %v, expected nil", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+
+		if err := host.Send([]byte(`{}`)); err == nil {
+			t.Error("Wanted Send() to return error after Close()")
+		}
+	})
+}
+
+func TestHostSizeLimits(t *testing.T) {
+	in, inPipe := io.Pipe()
+	outPipe, out := io.Pipe()
+	host := NewHostWithOptions(in, out, HostOptions{MaxRequestBytes: 8, MaxResponseBytes: 8})
+	go host.Start()
+	defer host.Close()
+
+	t.Run("Request", func(t *testing.T) {
+		go func() {
+			inPipe.Write(wire(`"123456789"`))
+			inPipe.Write(wire(`"ok"`))
+		}()
+		wantWrite(t, outPipe, `{"error":"11-byte request exceeds limit of 8 bytes","direction":"request","size":11,"limit":8}`)
+
+		// The host should carry on with the next request.
+		request, responder := host.Receive()
+		if string(request) != `"ok"` {
+			t.Errorf("Got unexpected request %s", request)
+		}
+		go responder.Respond([]byte(`"ok"`))
+		wantWrite(t, outPipe, `"ok"`)
+	})
+
+	t.Run("Response", func(t *testing.T) {
+		go inPipe.Write(wire(`"ok"`))
+		_, responder := host.Receive()
+		go responder.Respond([]byte(`"123456789"`))
+		wantWrite(t, outPipe, `{"error":"11-byte response exceeds limit of 8 bytes","direction":"response","size":11,"limit":8}`)
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		err := host.Send([]byte(`"123456789"`))
+		var sizeErr *MessageSizeError
+		if !errors.As(err, &sizeErr) || sizeErr.Size != 11 {
+			t.Errorf("Wanted *MessageSizeError, got %v", err)
+		}
+	})
+}
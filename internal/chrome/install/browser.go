@@ -0,0 +1,116 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Browser is a browser that supports native messaging hosts, along with where
+// it looks for their manifests.
+type Browser struct {
+	// Name is the short name for selecting the browser, e.g. "chrome".
+	Name string
+
+	// Firefox is true if the browser uses Firefox's manifest format (with
+	// AllowedExtensions rather than AllowedOrigins).
+	Firefox bool
+
+	// userDir is the per-user manifest directory, relative to the home
+	// directory (macOS and Linux).
+	userDir string
+
+	// systemDir is the system-wide manifest directory (macOS and Linux), or
+	// empty if there isn't one.
+	systemDir string
+
+	// profileDir is the browser's profile root, relative to the home
+	// directory.  It exists if the browser has been used.
+	profileDir string
+
+	// keyPath is the path under the registry root to register manifests
+	// (Windows).
+	keyPath string
+}
+
+// Browsers returns the browsers known on this platform.
+func Browsers() []Browser {
+	return append([]Browser(nil), browsers...)
+}
+
+// LookupBrowser returns the browser with the given name.
+func LookupBrowser(name string) (Browser, bool) {
+	for _, b := range browsers {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Browser{}, false
+}
+
+// Detected returns the browsers with a profile root in the given home
+// directory, i.e. those that the user has run.
+func Detected(homeDir string) []Browser {
+	var detected []Browser
+	for _, b := range browsers {
+		if fi, err := os.Stat(filepath.Join(homeDir, b.profileDir)); err == nil && fi.IsDir() {
+			detected = append(detected, b)
+		}
+	}
+	return detected
+}
+
+// AllBrowsers selects every detected browser in ParseBrowsers.
+const AllBrowsers = "all"
+
+// ParseBrowsers returns the browsers named in a comma-separated list, e.g.
+// "chrome,brave".  AllBrowsers selects the browsers detected in homeDir.
+func ParseBrowsers(list string, homeDir string) ([]Browser, error) {
+	if list == AllBrowsers {
+		detected := Detected(homeDir)
+		if len(detected) == 0 {
+			return nil, fmt.Errorf("no supported browsers found in %s", homeDir)
+		}
+		return detected, nil
+	}
+
+	var selected []Browser
+	for _, name := range strings.Split(list, ",") {
+		b, ok := LookupBrowser(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown browser %q; wanted one of %s", name, browserNames())
+		}
+		selected = append(selected, b)
+	}
+	return selected, nil
+}
+
+// browserNames lists the names of the known browsers.
+func browserNames() string {
+	names := make([]string, len(browsers))
+	for i, b := range browsers {
+		names[i] = b.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// CurrentUser installs a Chrome manifest for the calling user.
+func CurrentUser(m Manifest, opts ...Option) error {
+	return Chrome.InstallCurrentUser(m, opts...)
+}
+
+// System installs a Chrome manifest system-wide.
+func System(m Manifest, opts ...Option) error {
+	return Chrome.InstallSystem(m, opts...)
+}
+
+// FirefoxCurrentUser installs a Firefox manifest for the calling user.
+func FirefoxCurrentUser(m Manifest, opts ...Option) error {
+	return Firefox.InstallCurrentUser(m, opts...)
+}
+
+// FirefoxSystem installs a Firefox manifest system-wide.
+func FirefoxSystem(m Manifest, opts ...Option) error {
+	return Firefox.InstallSystem(m, opts...)
+}
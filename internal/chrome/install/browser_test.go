@@ -0,0 +1,55 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBrowsers(t *testing.T) {
+	homeDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(homeDir, Firefox.profileDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{list: "chrome", want: []string{"chrome"}},
+		{list: "brave, edge", want: []string{"brave", "edge"}},
+		{list: AllBrowsers, want: []string{"firefox"}},
+		{list: "netscape", wantErr: true},
+		{list: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := ParseBrowsers(tt.list, homeDir)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Wanted %v, got %v", tt.want, got)
+			}
+			for i, b := range got {
+				if b.Name != tt.want[i] {
+					t.Errorf("Wanted %s, got %s", tt.want[i], b.Name)
+				}
+			}
+		})
+	}
+
+	t.Run("NoneDetected", func(t *testing.T) {
+		if _, err := ParseBrowsers(AllBrowsers, t.TempDir()); err == nil {
+			t.Error("Wanted error with no browsers detected")
+		}
+	})
+}
@@ -2,20 +2,29 @@ package install
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 )
 
-// Manifest models the Chrome native messaging host manifest JSON.
+// Manifest models the native messaging host manifest JSON.
+//
+// Chrome manifests list AllowedOrigins, and Firefox manifests list
+// AllowedExtensions (add-on IDs) instead.
 //
 // See the official Chrome documentation at:
 // https://developer.chrome.com/docs/apps/nativeMessaging/#native-messaging-host
+// and the Firefox documentation at:
+// https://developer.mozilla.org/en-US/docs/Mozilla/Add-ons/WebExtensions/Native_manifests
 type Manifest struct {
-	Name           string   `json:"name"`
-	Description    string   `json:"description"`
-	Path           string   `json:"path"`
-	AllowedOrigins []string `json:"allowed_origins"`
-	Typ            string   `json:"type"`
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+	Typ               string   `json:"type"`
 }
 
 // manifestType is the (only supported) value for the "type" field in the
@@ -33,10 +42,91 @@ func (m Manifest) Filename() string {
 	return m.Name + ".json"
 }
 
-// install writes the serialized manifest buffer to the given path.
-func install(name string, buf []byte) error {
-	if err := os.WriteFile(name, buf, 0644); err != nil {
+// Option configures how a manifest is installed.
+type Option func(*options)
+
+type options struct {
+	backup bool
+}
+
+// WithBackup keeps a copy of the previously installed manifest (if any) next
+// to it, with a ".bak" suffix, for rolling back.
+func WithBackup() Option {
+	return func(o *options) {
+		o.backup = true
+	}
+}
+
+// BackupSuffix is appended to a manifest's path to name its backup.
+const BackupSuffix = ".bak"
+
+// Modes for the manifest directories that install creates.  Only the user
+// needs to read per-user manifests, but every user's browser needs to read
+// system-wide ones.
+const (
+	userDirMode   fs.FileMode = 0700
+	systemDirMode fs.FileMode = 0755
+)
+
+// install writes the serialized manifest buffer to the given path.  It
+// creates any missing parent directories with the given mode, and replaces the
+// manifest atomically, so that an interrupted write can't leave it truncated.
+func install(name string, dirMode fs.FileMode, buf []byte, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return fmt.Errorf(`creating manifest directory: %w`, err)
+	}
+
+	if o.backup {
+		switch old, err := os.ReadFile(name); {
+		case errors.Is(err, fs.ErrNotExist):
+			// Nothing to back up.
+		case err != nil:
+			return fmt.Errorf(`reading previous manifest: %w`, err)
+		default:
+			if err = os.WriteFile(name+BackupSuffix, old, 0644); err != nil {
+				return fmt.Errorf(`writing manifest backup: %w`, err)
+			}
+		}
+	}
+
+	if err := writeAtomic(name, buf); err != nil {
 		return fmt.Errorf(`writing manifest: %w`, err)
 	}
 	return nil
 }
+
+// writeAtomic writes buf to a temporary file in the same directory as name,
+// and then renames it to name.
+func writeAtomic(name string, buf []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// Clean up on failure; after a successful rename this is a no-op.
+	defer os.Remove(tmp)
+
+	if _, err = f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// CreateTemp uses mode 0600, but browsers (and other users, for
+	// system-wide installs) need to read the manifest.
+	if err = os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -1,8 +1,55 @@
 package install
 
-// systemDir is the system-wide install location on macOS.
-const systemDir = "/Library/Google/Chrome/NativeMessagingHosts"
+// appSupport is the per-user application data directory, relative to a user's
+// home directory on macOS.
+const appSupport = "Library/Application Support/"
 
-// userSubDir is the user-specific install location, relative to a user's home
-// directory on macOS.
-const userSubDir = "Library/Application Support/Google/Chrome/NativeMessagingHosts"
+// Chrome is Google Chrome.
+var Chrome = Browser{
+	Name:       "chrome",
+	userDir:    appSupport + "Google/Chrome/NativeMessagingHosts",
+	systemDir:  "/Library/Google/Chrome/NativeMessagingHosts",
+	profileDir: appSupport + "Google/Chrome",
+}
+
+// Firefox is Mozilla Firefox.
+var Firefox = Browser{
+	Name:       "firefox",
+	Firefox:    true,
+	userDir:    appSupport + "Mozilla/NativeMessagingHosts",
+	systemDir:  "/Library/Application Support/Mozilla/NativeMessagingHosts",
+	profileDir: appSupport + "Firefox",
+}
+
+// browsers are the browsers known on macOS.
+var browsers = []Browser{
+	Chrome,
+	{
+		Name:       "chromium",
+		userDir:    appSupport + "Chromium/NativeMessagingHosts",
+		systemDir:  "/Library/Application Support/Chromium/NativeMessagingHosts",
+		profileDir: appSupport + "Chromium",
+	},
+	{
+		Name:       "brave",
+		userDir:    appSupport + "BraveSoftware/Brave-Browser/NativeMessagingHosts",
+		profileDir: appSupport + "BraveSoftware/Brave-Browser",
+	},
+	{
+		Name:       "edge",
+		userDir:    appSupport + "Microsoft Edge/NativeMessagingHosts",
+		systemDir:  "/Library/Microsoft/Edge/NativeMessagingHosts",
+		profileDir: appSupport + "Microsoft Edge",
+	},
+	{
+		Name:       "vivaldi",
+		userDir:    appSupport + "Vivaldi/NativeMessagingHosts",
+		profileDir: appSupport + "Vivaldi",
+	},
+	{
+		Name:       "opera",
+		userDir:    appSupport + "com.operasoftware.Opera/NativeMessagingHosts",
+		profileDir: appSupport + "com.operasoftware.Opera",
+	},
+	Firefox,
+}
@@ -1,8 +1,51 @@
 package install
 
-// systemDir is the system-wide install location on Linux.
-const systemDir = "/etc/opt/chrome/native-messaging-hosts"
+// Chrome is Google Chrome.
+var Chrome = Browser{
+	Name:       "chrome",
+	userDir:    ".config/google-chrome/NativeMessagingHosts",
+	systemDir:  "/etc/opt/chrome/native-messaging-hosts",
+	profileDir: ".config/google-chrome",
+}
 
-// userSubDir is the user-specific install location, relative to a user's home
-// directory on Linux.
-const userSubDir = ".config/google-chrome/NativeMessagingHosts"
+// Firefox is Mozilla Firefox.
+var Firefox = Browser{
+	Name:       "firefox",
+	Firefox:    true,
+	userDir:    ".mozilla/native-messaging-hosts",
+	systemDir:  "/usr/lib/mozilla/native-messaging-hosts",
+	profileDir: ".mozilla/firefox",
+}
+
+// browsers are the browsers known on Linux.
+var browsers = []Browser{
+	Chrome,
+	{
+		Name:       "chromium",
+		userDir:    ".config/chromium/NativeMessagingHosts",
+		systemDir:  "/etc/chromium/native-messaging-hosts",
+		profileDir: ".config/chromium",
+	},
+	{
+		Name:       "brave",
+		userDir:    ".config/BraveSoftware/Brave-Browser/NativeMessagingHosts",
+		profileDir: ".config/BraveSoftware/Brave-Browser",
+	},
+	{
+		Name:       "edge",
+		userDir:    ".config/microsoft-edge/NativeMessagingHosts",
+		systemDir:  "/etc/opt/edge/native-messaging-hosts",
+		profileDir: ".config/microsoft-edge",
+	},
+	{
+		Name:       "vivaldi",
+		userDir:    ".config/vivaldi/NativeMessagingHosts",
+		profileDir: ".config/vivaldi",
+	},
+	{
+		Name:       "opera",
+		userDir:    ".config/opera/NativeMessagingHosts",
+		profileDir: ".config/opera",
+	},
+	Firefox,
+}
@@ -0,0 +1,67 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestInstall(t *testing.T) {
+	// The parent directories don't exist yet.
+	dir := filepath.Join(t.TempDir(), "a", "NativeMessagingHosts")
+	name := filepath.Join(dir, "com.example.host.json")
+
+	wantFile := func(t *testing.T, path, want string) {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != want {
+			t.Errorf("Wanted %s to contain %s, got %s", path, want, buf)
+		}
+	}
+
+	t.Run("CreatesDirectories", func(t *testing.T) {
+		if err := install(name, userDirMode, []byte(`{"v":1}`)); err != nil {
+			t.Fatal(err)
+		}
+		wantFile(t, name, `{"v":1}`)
+
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+			t.Errorf("Wanted mode 0644, got %v", fi.Mode().Perm())
+		}
+
+		fi, err = os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != userDirMode {
+			t.Errorf("Wanted directory mode %v, got %v", userDirMode, fi.Mode().Perm())
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		if err := install(name, userDirMode, []byte(`{"v":2}`), WithBackup()); err != nil {
+			t.Fatal(err)
+		}
+		wantFile(t, name, `{"v":2}`)
+		wantFile(t, name+BackupSuffix, `{"v":1}`)
+	})
+
+	t.Run("NoTempFiles", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name() != "com.example.host.json" && e.Name() != "com.example.host.json"+BackupSuffix {
+				t.Errorf("Unexpected file %s", e.Name())
+			}
+		}
+	})
+}
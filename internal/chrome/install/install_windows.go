@@ -1,62 +1,208 @@
 package install
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 import "golang.org/x/sys/windows/registry"
 
-// CurrentUser writes a Chrome manifest in the current directory, and registers
-// it in the Windows registry under HKEY_CURRENT_USER.
-func CurrentUser(m Manifest) error {
-	return writeManifestAndRegister(m, registry.CURRENT_USER)
+// chromeKeyPath is the path under the registry root to register Chrome native
+// messaging hosts.
+const chromeKeyPath = `SOFTWARE\Google\Chrome\NativeMessagingHosts`
+
+// Chrome is Google Chrome.
+var Chrome = Browser{
+	Name:       "chrome",
+	keyPath:    chromeKeyPath,
+	profileDir: "AppData/Local/Google/Chrome/User Data",
+}
+
+// Firefox is Mozilla Firefox.
+var Firefox = Browser{
+	Name:       "firefox",
+	Firefox:    true,
+	keyPath:    `SOFTWARE\Mozilla\NativeMessagingHosts`,
+	profileDir: "AppData/Roaming/Mozilla/Firefox",
+}
+
+// browsers are the browsers known on Windows.
+var browsers = []Browser{
+	Chrome,
+	{
+		Name:       "chromium",
+		keyPath:    `SOFTWARE\Chromium\NativeMessagingHosts`,
+		profileDir: "AppData/Local/Chromium/User Data",
+	},
+	{
+		Name:       "brave",
+		keyPath:    `SOFTWARE\BraveSoftware\Brave-Browser\NativeMessagingHosts`,
+		profileDir: "AppData/Local/BraveSoftware/Brave-Browser/User Data",
+	},
+	{
+		Name:       "edge",
+		keyPath:    `SOFTWARE\Microsoft\Edge\NativeMessagingHosts`,
+		profileDir: "AppData/Local/Microsoft/Edge/User Data",
+	},
+	{
+		// Vivaldi reads Chrome's registrations.
+		Name:       "vivaldi",
+		keyPath:    chromeKeyPath,
+		profileDir: "AppData/Local/Vivaldi/User Data",
+	},
+	{
+		// Opera reads Chrome's registrations.
+		Name:       "opera",
+		keyPath:    chromeKeyPath,
+		profileDir: "AppData/Roaming/Opera Software/Opera Stable",
+	},
+	Firefox,
+}
+
+// InstallCurrentUser writes the manifest in the current directory, and
+// registers it in the Windows registry under HKEY_CURRENT_USER.
+func (b Browser) InstallCurrentUser(m Manifest, opts ...Option) error {
+	return b.writeManifestAndRegister(m, registry.CURRENT_USER, userDirMode, opts...)
 }
 
-// System writes a Chrome manifest in the current directory, and registers
+// InstallSystem writes the manifest in the current directory, and registers
 // it in the Windows registry under HKEY_LOCAL_MACHINE.
-func System(m Manifest) error {
-	return writeManifestAndRegister(m, registry.LOCAL_MACHINE)
+func (b Browser) InstallSystem(m Manifest, opts ...Option) error {
+	return b.writeManifestAndRegister(m, registry.LOCAL_MACHINE, systemDirMode, opts...)
 }
 
-func writeManifestAndRegister(m Manifest, root registry.Key) error {
+// CheckCurrentUser compares the manifest registered under HKEY_CURRENT_USER
+// with m.
+func (b Browser) CheckCurrentUser(m Manifest) ([]Drift, error) {
+	manifestPath, err := registered(registry.CURRENT_USER, fmt.Sprintf(`%s\%s`, b.keyPath, m.Name))
+	if errors.Is(err, registry.ErrNotExist) {
+		return Diff(nil, m), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return diffFile(manifestPath, m), nil
+}
+
+// filename is the name for the browser's manifest file.  Firefox manifests
+// are distinct from Chrome manifests so that both can live in the same
+// directory.
+func (b Browser) filename(m Manifest) string {
+	if b.Firefox {
+		return m.Name + ".firefox.json"
+	}
+	return m.Filename()
+}
+
+func (b Browser) writeManifestAndRegister(m Manifest, root registry.Key, dirMode fs.FileMode, opts ...Option) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
-	manifestPath, err := writeManifest(cwd, m)
+	manifestPath, err := writeManifest(filepath.Join(cwd, b.filename(m)), dirMode, m, opts...)
 	if err != nil {
 		return err
 	}
-	return register(root, m.Name, manifestPath)
+	return register(root, b.keyPath, m.Name, manifestPath)
 }
 
-func writeManifest(dir string, m Manifest) (string, error) {
+func writeManifest(manifestPath string, dirMode fs.FileMode, m Manifest, opts ...Option) (string, error) {
 	buf, err := m.Marshal()
 	if err != nil {
 		return "", err
 	}
 
-	manifestPath := filepath.Join(dir, m.Filename())
-	if err = install(manifestPath, buf); err != nil {
+	if err = install(manifestPath, dirMode, buf, opts...); err != nil {
 		return "", err
 	}
 
 	return manifestPath, nil
 }
 
-// keyPath is the path under the registry root to register Chrome native
-// messaging hosts.
-const keyPath = `SOFTWARE\Google\Chrome\NativeMessagingHosts`
-
 // register registers the native messaging host in the Windows registry.
-func register(root registry.Key, name string, manifestPath string) error {
+func register(root registry.Key, keyPath string, name string, manifestPath string) error {
 	p := fmt.Sprintf(`%s\%s`, keyPath, name)
-	k, _, err := registry.CreateKey(registry.CURRENT_USER, p, registry.CREATE_SUB_KEY|registry.SET_VALUE)
+	k, _, err := registry.CreateKey(root, p, registry.CREATE_SUB_KEY|registry.SET_VALUE)
 	if err != nil {
 		return err
 	}
+	defer k.Close()
 	return k.SetStringValue("", manifestPath)
 }
+
+// locations returns the registry keys for the named host, for the current
+// user and system-wide.
+func locations(name string) ([]Registration, error) {
+	var regs []Registration
+	seen := make(map[string]bool)
+	for _, system := range []bool{false, true} {
+		for _, b := range browsers {
+			key := fmt.Sprintf(`%s\%s`, b.keyPath, name)
+			// Several browsers share Chrome's key.
+			id := fmt.Sprint(system, key)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			manifestPath, err := registered(rootKey(system), key)
+			if errors.Is(err, registry.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			regs = append(regs, Registration{
+				Browser:      b,
+				System:       system,
+				ManifestPath: manifestPath,
+				Key:          key,
+			})
+		}
+	}
+	return regs, nil
+}
+
+// rootKey returns the registry root for system-wide or current-user
+// registrations.
+func rootKey(system bool) registry.Key {
+	if system {
+		return registry.LOCAL_MACHINE
+	}
+	return registry.CURRENT_USER
+}
+
+// registered returns the manifest path registered at the given key.
+func registered(root registry.Key, key string) (string, error) {
+	k, err := registry.OpenKey(root, key, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+	manifestPath, _, err := k.GetStringValue("")
+	return manifestPath, err
+}
+
+// remove deletes the registration's registry key and manifest.
+func (r *Registration) remove() error {
+	if err := registry.DeleteKey(rootKey(r.System), r.Key); err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(r.ManifestPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// isExecutable returns true if the file is a regular file with an extension
+// that Windows can execute.
+func isExecutable(fi fs.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(fi.Name())) {
+	case ".exe", ".com", ".bat", ".cmd":
+		return fi.Mode().IsRegular()
+	}
+	return false
+}
@@ -3,37 +3,101 @@
 package install
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"os/user"
 	"path/filepath"
 )
 
-// CurrentUser installs the manifest for the calling user.
-func CurrentUser(m Manifest) error {
+// User installs a Chrome manifest to a user-specific directory.
+func User(m Manifest, homeDir string, opts ...Option) error {
+	return Chrome.InstallUser(m, homeDir, opts...)
+}
+
+// FirefoxUser installs a Firefox manifest to a user-specific directory.
+func FirefoxUser(m Manifest, homeDir string, opts ...Option) error {
+	return Firefox.InstallUser(m, homeDir, opts...)
+}
+
+// InstallCurrentUser installs the manifest for the calling user.
+func (b Browser) InstallCurrentUser(m Manifest, opts ...Option) error {
 	usr, err := user.Current()
 	if err != nil {
 		return err
 	}
-	return User(m, usr.HomeDir)
+	return b.InstallUser(m, usr.HomeDir, opts...)
+}
+
+// InstallUser installs the manifest to the browser's user-specific directory.
+func (b Browser) InstallUser(m Manifest, homeDir string, opts ...Option) error {
+	return installIn(filepath.Join(homeDir, b.userDir), userDirMode, m, opts...)
+}
+
+// CheckCurrentUser compares the calling user's installed manifest with m.
+func (b Browser) CheckCurrentUser(m Manifest) ([]Drift, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	return diffFile(filepath.Join(usr.HomeDir, b.userDir, m.Filename()), m), nil
 }
 
-// User creates and installs a Chrome manifest to a user-specific
-// directory.
-func User(m Manifest, homeDir string) error {
+// InstallSystem installs the manifest to the browser's system-wide directory.
+func (b Browser) InstallSystem(m Manifest, opts ...Option) error {
+	if b.systemDir == "" {
+		return fmt.Errorf("no system-wide manifest directory for %s", b.Name)
+	}
+	return installIn(b.systemDir, systemDirMode, m, opts...)
+}
+
+// installIn writes the manifest to the given directory, creating it with
+// dirMode if it's missing.
+func installIn(dir string, dirMode fs.FileMode, m Manifest, opts ...Option) error {
 	buf, err := m.Marshal()
 	if err != nil {
 		return err
 	}
-	name := filepath.Join(homeDir, userSubDir, m.Filename())
-	return install(name, buf)
+	return install(filepath.Join(dir, m.Filename()), dirMode, buf, opts...)
 }
 
-// System creates and installs a Chrome manifest to the system-wide
-// directory.
-func System(m Manifest) error {
-	buf, err := m.Marshal()
+// locations returns where each browser would look for the named host's
+// manifest, for the current user and system-wide.
+func locations(name string) ([]Registration, error) {
+	usr, err := user.Current()
 	if err != nil {
+		return nil, err
+	}
+
+	filename := Manifest{Name: name}.Filename()
+	var regs []Registration
+	for _, b := range browsers {
+		regs = append(regs, Registration{
+			Browser:      b,
+			ManifestPath: filepath.Join(usr.HomeDir, b.userDir, filename),
+		})
+		if b.systemDir != "" {
+			regs = append(regs, Registration{
+				Browser:      b,
+				System:       true,
+				ManifestPath: filepath.Join(b.systemDir, filename),
+			})
+		}
+	}
+	return regs, nil
+}
+
+// remove deletes the registration's manifest.
+func (r *Registration) remove() error {
+	if err := os.Remove(r.ManifestPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	name := filepath.Join(systemDir, m.Filename())
-	return install(name, buf)
+	return nil
+}
+
+// isExecutable returns true if the file is a regular file with any execute
+// permission bits set.
+func isExecutable(fi fs.FileInfo) bool {
+	return fi.Mode().IsRegular() && fi.Mode()&0111 != 0
 }
@@ -0,0 +1,65 @@
+//go:build darwin || linux
+
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestInstallUser(t *testing.T) {
+	m := Manifest{Name: "com.example.host", Path: "/opt/host"}
+
+	tests := []struct {
+		name    string
+		install func(Manifest, string, ...Option) error
+		wantDir map[string]string
+	}{
+		{
+			"Chrome",
+			User,
+			map[string]string{
+				"darwin": "Library/Application Support/Google/Chrome/NativeMessagingHosts",
+				"linux":  ".config/google-chrome/NativeMessagingHosts",
+			},
+		},
+		{
+			"Firefox",
+			FirefoxUser,
+			map[string]string{
+				"darwin": "Library/Application Support/Mozilla/NativeMessagingHosts",
+				"linux":  ".mozilla/native-messaging-hosts",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			homeDir := t.TempDir()
+			if err := tt.install(m, homeDir); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := filepath.Join(homeDir, filepath.FromSlash(tt.wantDir[runtime.GOOS]))
+			fi, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != userDirMode {
+				t.Errorf("Wanted directory mode %v, got %v", userDirMode, fi.Mode().Perm())
+			}
+
+			got, err := ReadManifest(filepath.Join(dir, "com.example.host.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := m
+			want.Typ = manifestType
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("Wanted %+v, got %+v", want, *got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ReadManifest decodes the manifest file at the given path.
+func ReadManifest(path string) (*Manifest, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("decoding manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Drift is a difference between an installed manifest and the wanted one.
+type Drift struct {
+	// Field is the JSON field that differs, or "file" if the manifest is
+	// missing or unreadable.
+	Field string
+
+	// Installed and Wanted are the values of scalar fields.  For an
+	// unreadable manifest, Installed is the reason.
+	Installed, Wanted string
+
+	// Added and Removed are the values missing from, or unexpected in, list
+	// fields.
+	Added, Removed []string
+}
+
+func (d Drift) String() string {
+	switch {
+	case d.Field == "file" && d.Installed != "":
+		return "manifest is unreadable: " + d.Installed
+	case d.Field == "file":
+		return "manifest is missing"
+	case d.Added != nil || d.Removed != nil:
+		var changes []string
+		if len(d.Added) > 0 {
+			changes = append(changes, "missing "+strings.Join(d.Added, ", "))
+		}
+		if len(d.Removed) > 0 {
+			changes = append(changes, "unexpected "+strings.Join(d.Removed, ", "))
+		}
+		return fmt.Sprintf("%s: %s", d.Field, strings.Join(changes, "; "))
+	default:
+		return fmt.Sprintf("%s is %q, wanted %q", d.Field, d.Installed, d.Wanted)
+	}
+}
+
+// Diff returns the differences between an installed manifest and the wanted
+// one.  installed is nil if the manifest is missing.  Lists are compared as
+// sets, so the order of origins doesn't matter.
+func Diff(installed *Manifest, wanted Manifest) []Drift {
+	if installed == nil {
+		return []Drift{{Field: "file"}}
+	}
+
+	var drift []Drift
+	scalar := func(field, installed, wanted string) {
+		if installed != wanted {
+			drift = append(drift, Drift{Field: field, Installed: installed, Wanted: wanted})
+		}
+	}
+	list := func(field string, installed, wanted []string) {
+		added, removed := setDiff(installed, wanted)
+		if len(added) > 0 || len(removed) > 0 {
+			drift = append(drift, Drift{Field: field, Added: added, Removed: removed})
+		}
+	}
+
+	scalar("name", installed.Name, wanted.Name)
+	scalar("description", installed.Description, wanted.Description)
+	scalar("path", installed.Path, wanted.Path)
+	list("allowed_origins", installed.AllowedOrigins, wanted.AllowedOrigins)
+	list("allowed_extensions", installed.AllowedExtensions, wanted.AllowedExtensions)
+	scalar("type", installed.Typ, manifestType)
+	return drift
+}
+
+// diffFile compares the manifest file at the given path with the wanted
+// manifest.  A manifest that can't be read or decoded (e.g. because a write
+// was truncated) has drifted, so that it gets rewritten.
+func diffFile(path string, wanted Manifest) []Drift {
+	installed, err := ReadManifest(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Diff(nil, wanted)
+	} else if err != nil {
+		return []Drift{{Field: "file", Installed: err.Error()}}
+	}
+	return Diff(installed, wanted)
+}
+
+// setDiff returns the (sorted) strings in wanted but not in installed, and
+// those in installed but not in wanted.
+func setDiff(installed, wanted []string) (added, removed []string) {
+	has := func(ss []string, s string) bool {
+		for _, t := range ss {
+			if t == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, s := range wanted {
+		if !has(installed, s) && !has(added, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range installed {
+		if !has(wanted, s) && !has(removed, s) {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
+}
@@ -0,0 +1,166 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	wanted := Manifest{
+		Name:           "com.example.host",
+		Description:    "Example",
+		Path:           "/opt/host",
+		AllowedOrigins: []string{"chrome-extension://a/", "chrome-extension://b/"},
+	}
+
+	tests := []struct {
+		name      string
+		installed *Manifest
+		want      []string
+	}{
+		{"Missing", nil, []string{"manifest is missing"}},
+		{
+			"Same",
+			&Manifest{
+				Name:           "com.example.host",
+				Description:    "Example",
+				Path:           "/opt/host",
+				AllowedOrigins: []string{"chrome-extension://b/", "chrome-extension://a/"},
+				Typ:            "stdio",
+			},
+			nil,
+		},
+		{
+			"Drifted",
+			&Manifest{
+				Name:           "com.example.host",
+				Description:    "Example",
+				Path:           "/usr/bin/host",
+				AllowedOrigins: []string{"chrome-extension://a/", "chrome-extension://c/"},
+				Typ:            "socket",
+			},
+			[]string{
+				`path is "/usr/bin/host", wanted "/opt/host"`,
+				"allowed_origins: missing chrome-extension://b/; unexpected chrome-extension://c/",
+				`type is "socket", wanted "stdio"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range Diff(tt.installed, wanted) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wanted %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	m := Manifest{
+		Name:              "com.example.host",
+		Path:              "/opt/host",
+		AllowedExtensions: []string{"host@example.com"},
+	}
+	buf, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), m.Filename())
+	if err = os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift := Diff(got, m); len(drift) > 0 {
+		t.Errorf("Wanted round-trip without drift, got %v", drift)
+	}
+}
+
+func TestDiffFile(t *testing.T) {
+	wanted := Manifest{Name: "com.example.host", Path: "/opt/host"}
+	buf, err := wanted.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contents []byte
+		want     []string
+	}{
+		{"Missing", nil, []string{"manifest is missing"}},
+		{"Same", buf, nil},
+		{"Truncated", buf[:len(buf)/2], []string{"manifest is unreadable: decoding manifest "}},
+		{"Empty", []byte{}, []string{"manifest is unreadable: decoding manifest "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), wanted.Filename())
+			if tt.contents != nil {
+				if err := os.WriteFile(path, tt.contents, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			drift := diffFile(path, wanted)
+			if len(drift) != len(tt.want) {
+				t.Fatalf("Wanted %q, got %v", tt.want, drift)
+			}
+			for i, d := range drift {
+				if got := d.String(); !strings.HasPrefix(got, tt.want[i]) {
+					t.Errorf("Wanted %q..., got %q", tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Manifest
+		want string
+	}{
+		{
+			"Chrome",
+			Manifest{
+				Name:           "com.example.host",
+				Description:    "Example",
+				Path:           "/opt/host",
+				AllowedOrigins: []string{"chrome-extension://a/"},
+			},
+			`{"name":"com.example.host","description":"Example","path":"/opt/host","allowed_origins":["chrome-extension://a/"],"type":"stdio"}`,
+		},
+		{
+			"Firefox",
+			Manifest{
+				Name:              "com.example.host",
+				Description:       "Example",
+				Path:              "/opt/host",
+				AllowedExtensions: []string{"host@example.com", "{A1B2C3D4-0000-4000-8000-00000000000F}"},
+			},
+			`{"name":"com.example.host","description":"Example","path":"/opt/host","allowed_extensions":["host@example.com","{A1B2C3D4-0000-4000-8000-00000000000F}"],"type":"stdio"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Wanted %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
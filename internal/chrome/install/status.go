@@ -0,0 +1,123 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Registration describes an installed native messaging host manifest.
+type Registration struct {
+	Browser Browser
+
+	// System is true for system-wide registrations, and false for the
+	// current user's.
+	System bool
+
+	// ManifestPath is the path to the manifest file.
+	ManifestPath string
+
+	// Key is the registry key that points to the manifest (Windows only).
+	Key string
+
+	// Manifest is the decoded manifest, or nil if it couldn't be read.
+	Manifest *Manifest
+
+	// Err is the error reading the manifest, if any.
+	Err error
+
+	// BinaryExists is true if the manifest's path exists, and
+	// BinaryExecutable is true if it can be executed.
+	BinaryExists, BinaryExecutable bool
+}
+
+// Describe returns a human-readable, multi-line description of the
+// registration, for status output.
+func (r Registration) Describe() string {
+	var sb strings.Builder
+	scope := "user"
+	if r.System {
+		scope = "system"
+	}
+	fmt.Fprintf(&sb, "%s (%s): %s\n", r.Browser.Name, scope, r.ManifestPath)
+	if r.Key != "" {
+		fmt.Fprintf(&sb, "    registry key: %s\n", r.Key)
+	}
+
+	switch {
+	case r.Err != nil:
+		fmt.Fprintf(&sb, "    error: %v\n", r.Err)
+	case !r.BinaryExists:
+		fmt.Fprintf(&sb, "    binary: %s (missing)\n", r.Manifest.Path)
+	case !r.BinaryExecutable:
+		fmt.Fprintf(&sb, "    binary: %s (not executable)\n", r.Manifest.Path)
+	default:
+		fmt.Fprintf(&sb, "    binary: %s\n", r.Manifest.Path)
+	}
+	return sb.String()
+}
+
+// Status returns where the named host is registered, for the current user
+// and system-wide, across all known browsers.
+func Status(name string) ([]Registration, error) {
+	candidates, err := locations(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var regs []Registration
+	for _, r := range candidates {
+		_, err := os.Stat(r.ManifestPath)
+		if errors.Is(err, fs.ErrNotExist) && r.Key == "" {
+			// Not registered with this browser.
+			continue
+		}
+		r.check()
+		regs = append(regs, r)
+	}
+	return regs, nil
+}
+
+// Uninstall removes the named host's manifests (and on Windows, registry
+// keys) for the current user, across all known browsers.  It returns the
+// registrations that were removed.
+func Uninstall(name string) ([]Registration, error) {
+	return uninstall(name, false)
+}
+
+// UninstallSystem is like Uninstall, but removes system-wide registrations.
+func UninstallSystem(name string) ([]Registration, error) {
+	return uninstall(name, true)
+}
+
+func uninstall(name string, system bool) ([]Registration, error) {
+	regs, err := Status(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Registration
+	for _, r := range regs {
+		if r.System != system {
+			continue
+		}
+		if err = r.remove(); err != nil {
+			return removed, fmt.Errorf("removing %s manifest %s: %w", r.Browser.Name, r.ManifestPath, err)
+		}
+		removed = append(removed, r)
+	}
+	return removed, nil
+}
+
+// check reads the manifest and checks the binary it points to.
+func (r *Registration) check() {
+	r.Manifest, r.Err = ReadManifest(r.ManifestPath)
+	if r.Err != nil {
+		return
+	}
+	fi, err := os.Stat(r.Manifest.Path)
+	r.BinaryExists = err == nil
+	r.BinaryExecutable = err == nil && isExecutable(fi)
+}
@@ -0,0 +1,124 @@
+//go:build !windows
+
+package install
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistrationCheck(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "host")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifestPath := filepath.Join(dir, "com.example.host.json")
+	buf, err := Manifest{Name: "com.example.host", Path: binary}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(manifestPath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T) Registration {
+		r := Registration{Browser: Chrome, ManifestPath: manifestPath}
+		r.check()
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r.Manifest.Path != binary {
+			t.Errorf("Wanted binary %s, got %s", binary, r.Manifest.Path)
+		}
+		return r
+	}
+
+	t.Run("NotExecutable", func(t *testing.T) {
+		if r := check(t); !r.BinaryExists || r.BinaryExecutable {
+			t.Errorf("Wanted existing non-executable binary, got %+v", r)
+		}
+	})
+
+	t.Run("Executable", func(t *testing.T) {
+		if err := os.Chmod(binary, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if r := check(t); !r.BinaryExists || !r.BinaryExecutable {
+			t.Errorf("Wanted executable binary, got %+v", r)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if err := os.Remove(binary); err != nil {
+			t.Fatal(err)
+		}
+		if r := check(t); r.BinaryExists {
+			t.Errorf("Wanted missing binary, got %+v", r)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		r := Registration{Browser: Chrome, ManifestPath: manifestPath}
+		if err := r.remove(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+			t.Errorf("Wanted manifest removed, got %v", err)
+		}
+		// Removing again is not an error.
+		if err := r.remove(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRegistrationDescribe(t *testing.T) {
+	manifest := &Manifest{Path: "/bin/host"}
+	tests := []struct {
+		name string
+		reg  Registration
+		want string
+	}{
+		{
+			name: "Installed",
+			reg:  Registration{Browser: Chrome, ManifestPath: "/m.json", Manifest: manifest, BinaryExists: true, BinaryExecutable: true},
+			want: "chrome (user): /m.json\n    binary: /bin/host\n",
+		},
+		{
+			name: "System",
+			reg:  Registration{Browser: Chrome, System: true, ManifestPath: "/m.json", Manifest: manifest, BinaryExists: true, BinaryExecutable: true},
+			want: "chrome (system): /m.json\n    binary: /bin/host\n",
+		},
+		{
+			name: "Key",
+			reg:  Registration{Browser: Chrome, ManifestPath: "/m.json", Key: `Software\Host`, Manifest: manifest, BinaryExists: true, BinaryExecutable: true},
+			want: "chrome (user): /m.json\n    registry key: Software\\Host\n    binary: /bin/host\n",
+		},
+		{
+			name: "Missing",
+			reg:  Registration{Browser: Chrome, ManifestPath: "/m.json", Manifest: manifest},
+			want: "chrome (user): /m.json\n    binary: /bin/host (missing)\n",
+		},
+		{
+			name: "NotExecutable",
+			reg:  Registration{Browser: Chrome, ManifestPath: "/m.json", Manifest: manifest, BinaryExists: true},
+			want: "chrome (user): /m.json\n    binary: /bin/host (not executable)\n",
+		},
+		{
+			name: "Error",
+			reg:  Registration{Browser: Chrome, ManifestPath: "/m.json", Err: errors.New("bad JSON")},
+			want: "chrome (user): /m.json\n    error: bad JSON\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.reg.Describe(); got != tt.want {
+				t.Errorf("Wanted %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
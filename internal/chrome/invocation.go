@@ -0,0 +1,113 @@
+package chrome
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Browser is the family of browser that started a native messaging host.
+type Browser string
+
+const (
+	// Chromium is Chrome, or another Chromium-based browser.
+	Chromium Browser = "chromium"
+
+	// Firefox is Firefox, or another Gecko-based browser.
+	Firefox Browser = "firefox"
+)
+
+// chromeScheme is the scheme of Chrome extension origins.
+const chromeScheme = "chrome-extension://"
+
+// parentWindowFlag precedes the handle of the calling window, which Chrome
+// passes on Windows.
+const parentWindowFlag = "--parent-window="
+
+// Invocation describes how a browser started the native messaging host.
+//
+// Chromium passes the caller's origin, e.g.
+// "chrome-extension://abcdefghijklmnopabcdefghijklmnop/", and on Windows also
+// a "--parent-window=" flag.  Firefox passes the path to the host's manifest
+// and the caller's add-on ID.
+type Invocation struct {
+	Browser Browser
+
+	// Origin is the caller's origin.  It's empty for Firefox.
+	Origin string
+
+	// ExtensionID is the caller's extension ID (lowercase for Chromium), or
+	// add-on ID for Firefox.
+	ExtensionID string
+
+	// ManifestPath is the path to the host's manifest.  It's empty for
+	// Chromium.
+	ManifestPath string
+
+	// ParentWindow is the native handle of the calling window, if the browser
+	// passed one (Chromium on Windows).
+	ParentWindow string
+
+	// Args are the raw arguments.
+	Args []string
+}
+
+// ParseInvocation classifies the arguments the browser started the host with
+// (excluding the program name, i.e. os.Args[1:]).
+func ParseInvocation(args []string) (*Invocation, error) {
+	inv := &Invocation{Args: args}
+
+	var positional []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, parentWindowFlag) {
+			inv.ParentWindow = strings.TrimPrefix(arg, parentWindowFlag)
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) == 0 {
+		return nil, fmt.Errorf("wanted origin or manifest path argument, got %d args", len(args))
+	}
+
+	if strings.HasPrefix(strings.ToLower(positional[0]), chromeScheme) {
+		id, err := chromeExtensionID(positional[0])
+		if err != nil {
+			return nil, err
+		}
+		inv.Browser = Chromium
+		inv.ExtensionID = id
+		inv.Origin = chromeScheme + id + "/"
+		return inv, nil
+	}
+
+	if len(positional) < 2 {
+		return nil, fmt.Errorf("wanted manifest path and add-on ID arguments, got %q", positional)
+	}
+	id := strings.TrimSpace(positional[1])
+	if id == "" {
+		return nil, fmt.Errorf("empty add-on ID")
+	}
+	if strings.HasPrefix(id, "{") {
+		// UUID-style IDs are case-insensitive.
+		id = strings.ToLower(id)
+	}
+	inv.Browser = Firefox
+	inv.ManifestPath = positional[0]
+	inv.ExtensionID = id
+	return inv, nil
+}
+
+// chromeExtensionID returns the (lowercased) extension ID from a Chrome
+// extension origin.  IDs are 32 letters from "a" to "p".
+func chromeExtensionID(origin string) (string, error) {
+	id := strings.ToLower(origin[len(chromeScheme):])
+	id = strings.TrimSuffix(id, "/")
+	if len(id) != 32 {
+		return "", fmt.Errorf("invalid extension ID in origin %q", origin)
+	}
+	for _, c := range id {
+		if c < 'a' || c > 'p' {
+			return "", fmt.Errorf("invalid extension ID in origin %q", origin)
+		}
+	}
+	return id, nil
+}
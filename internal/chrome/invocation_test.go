@@ -0,0 +1,85 @@
+package chrome
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInvocation(t *testing.T) {
+	const (
+		id     = "nglhipbdoknhpejdpceibmeaohidgcod"
+		origin = "chrome-extension://" + id + "/"
+	)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    *Invocation
+		wantErr bool
+	}{
+		{
+			name: "Chrome",
+			args: []string{origin},
+			want: &Invocation{Browser: Chromium, Origin: origin, ExtensionID: id},
+		},
+		{
+			name: "ChromeWindows",
+			args: []string{origin, "--parent-window=6752"},
+			want: &Invocation{Browser: Chromium, Origin: origin, ExtensionID: id, ParentWindow: "6752"},
+		},
+		{
+			name: "ChromeUppercase",
+			args: []string{"Chrome-Extension://NGLHIPBDOKNHPEJDPCEIBMEAOHIDGCOD/"},
+			want: &Invocation{Browser: Chromium, Origin: origin, ExtensionID: id},
+		},
+		{
+			name: "ChromeNoTrailingSlash",
+			args: []string{"chrome-extension://" + id},
+			want: &Invocation{Browser: Chromium, Origin: origin, ExtensionID: id},
+		},
+		{
+			name: "Firefox",
+			args: []string{"/home/u/.mozilla/native-messaging-hosts/io.github.p00ya.cdb.json", "cdb@p00ya.github.io"},
+			want: &Invocation{
+				Browser:      Firefox,
+				ExtensionID:  "cdb@p00ya.github.io",
+				ManifestPath: "/home/u/.mozilla/native-messaging-hosts/io.github.p00ya.cdb.json",
+			},
+		},
+		{
+			name: "FirefoxUUID",
+			args: []string{`C:\cdb\io.github.p00ya.cdb.json`, "{2A7E3BE0-5A3C-4F4A-9D8B-2B4E6E8E1C3F}"},
+			want: &Invocation{
+				Browser:      Firefox,
+				ExtensionID:  "{2a7e3be0-5a3c-4f4a-9d8b-2b4e6e8e1c3f}",
+				ManifestPath: `C:\cdb\io.github.p00ya.cdb.json`,
+			},
+		},
+		{name: "NoArgs", args: nil, wantErr: true},
+		{name: "OnlyParentWindow", args: []string{"--parent-window=0"}, wantErr: true},
+		{name: "ShortExtensionID", args: []string{"chrome-extension://abc/"}, wantErr: true},
+		{name: "InvalidExtensionID", args: []string{"chrome-extension://zglhipbdoknhpejdpceibmeaohidgcod/"}, wantErr: true},
+		{name: "FirefoxMissingID", args: []string{"/path/to/manifest.json"}, wantErr: true},
+		{name: "FirefoxEmptyID", args: []string{"/path/to/manifest.json", " "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInvocation(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Wanted error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.Args = tt.args
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wanted %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@
 package discord
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
 // getDiscordSocket constructs a path to a Discord IPC socket.
@@ -15,26 +18,152 @@ func getDiscordSocket(tmpDir string, n int) string {
 	return fmt.Sprintf("%s/discord-ipc-%d", tmpDir, n)
 }
 
-// Dial opens the Discord socket and returns a client for sending messages.
-func dialIn(tmpDir string) (*Client, error) {
-	var err error
+// tmpDirEnvs are the environment variables that may name the directory
+// Discord creates its sockets in, in the order the official client checks
+// them.
+var tmpDirEnvs = []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"}
 
+// sandboxSubDirs are the sub-directories of the temporary directory that
+// sandboxed installs of Discord create their sockets in.  The empty string is
+// for unsandboxed installs.
+var sandboxSubDirs = []string{
+	"",
+	// Flatpak.
+	"app/com.discordapp.Discord",
+	// Snap.
+	"snap.discord",
+}
+
+// socketDirs returns the directories that may contain Discord sockets, in the
+// order they should be searched.
+func socketDirs(getenv func(string) string) []string {
+	var bases []string
+	for _, env := range tmpDirEnvs {
+		if dir := getenv(env); dir != "" {
+			bases = append(bases, dir)
+		}
+	}
+	bases = append(bases, "/tmp")
+
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, base := range bases {
+		for _, sub := range sandboxSubDirs {
+			dir := filepath.Join(base, sub)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// socketsIn returns the paths of the Discord sockets that may exist in dir.
+func socketsIn(dir string) []string {
 	// Socket may be numbered from 0 to 9.
-	for i := 0; i < 10; i++ {
-		addr := getDiscordSocket(tmpDir, i)
+	addrs := make([]string, 10)
+	for i := range addrs {
+		addrs[i] = getDiscordSocket(dir, i)
+	}
+	return addrs
+}
+
+// candidateSockets returns the paths of the Discord sockets that may exist,
+// in the order they should be tried.  If IPCPathEnv is set, it is the only
+// candidate.
+func candidateSockets(getenv func(string) string) []string {
+	if addr := getenv(IPCPathEnv); addr != "" {
+		return []string{addr}
+	}
+
+	var addrs []string
+	for _, dir := range socketDirs(getenv) {
+		addrs = append(addrs, socketsIn(dir)...)
+	}
+	return addrs
+}
+
+// dialIn opens a Discord socket in tmpDir and returns a client for sending
+// messages.
+func dialIn(ctx context.Context, tmpDir string) (*Client, error) {
+	return dialFirst(ctx, socketsIn(tmpDir))
+}
+
+// dialFirst opens the first of the given Discord sockets that accepts a
+// connection.
+func dialFirst(ctx context.Context, addrs []string) (*Client, error) {
+	dialErr := &DialError{}
 
-		var conn net.Conn
-		// Go's "unix" network is equivalent to AF_UNIX/SOCK_STREAM.
-		if conn, err = net.Dial("unix", addr); err != nil {
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		client, err := DialAddr(ctx, addr)
+		if err != nil {
+			dialErr.add(addr, err)
 			continue
 		}
 
-		return newClient(conn), nil
+		return client, nil
+	}
+
+	return nil, dialErr
+}
+
+// DialAddr opens the Discord socket at the given path, rather than searching
+// for it.
+//
+// The socket must be owned by the current user (and on Linux, so must the
+// process listening on it), otherwise an *UntrustedSocketError is returned.
+// This stops other users from impersonating Discord by creating a socket in
+// a shared temporary directory.
+func DialAddr(ctx context.Context, addr string) (*Client, error) {
+	if err := checkSocketFile(addr); err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	// Go's "unix" network is equivalent to AF_UNIX/SOCK_STREAM.
+	conn, err := d.DialContext(ctx, "unix", addr)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("got errors opening Discord sockets, last was: %w", err)
+	if err = checkPeer(conn.(*net.UnixConn)); err != nil {
+		conn.Close()
+		return nil, &UntrustedSocketError{addr, err.Error()}
+	}
+	return newClient(conn), nil
 }
 
-func Dial() (*Client, error) {
-	return dialIn(os.TempDir())
+// checkSocketFile verifies that the file at addr is a socket owned by the
+// current user.  It returns an *UntrustedSocketError if not, or an error from
+// os.Stat if the file doesn't exist.
+func checkSocketFile(addr string) error {
+	fi, err := os.Stat(addr)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return &UntrustedSocketError{addr, fmt.Sprintf("mode %v is not a socket", fi.Mode())}
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if uid := os.Getuid(); int(st.Uid) != uid {
+			return &UntrustedSocketError{addr, fmt.Sprintf("owned by UID %d, not %d", st.Uid, uid)}
+		}
+	}
+	return nil
+}
+
+// candidates returns the paths of the Discord sockets that may exist, in the
+// order they should be tried.
+//
+// Like the official client, it searches the directories named by the
+// XDG_RUNTIME_DIR, TMPDIR, TMP and TEMP environment variables (and then
+// /tmp), including the sub-directories used by Flatpak and Snap installs.
+func candidates() []string {
+	return candidateSockets(os.Getenv)
 }
@@ -3,9 +3,13 @@
 package discord
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -58,7 +62,7 @@ func TestDial(t *testing.T) {
 
 	clientDone := make(chan *Client)
 	go func() {
-		client, err := dialIn(fake.TmpDir)
+		client, err := dialIn(context.Background(), fake.TmpDir)
 		if err != nil {
 			t.Errorf("Got %v while dialing", err)
 		}
@@ -100,3 +104,107 @@ func TestGetDiscordSocket(t *testing.T) {
 		})
 	}
 }
+
+func TestCandidateSockets(t *testing.T) {
+	var tests = []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"Defaults", map[string]string{}, []string{
+			"/tmp/discord-ipc-0",
+			"/tmp/app/com.discordapp.Discord/discord-ipc-0",
+			"/tmp/snap.discord/discord-ipc-0",
+		}},
+		{"Order", map[string]string{"TEMP": "/temp", "XDG_RUNTIME_DIR": "/run/user/1000", "TMPDIR": "/tmp/"}, []string{
+			"/run/user/1000/discord-ipc-0",
+			"/run/user/1000/app/com.discordapp.Discord/discord-ipc-0",
+			"/run/user/1000/snap.discord/discord-ipc-0",
+			"/tmp/discord-ipc-0",
+			"/tmp/app/com.discordapp.Discord/discord-ipc-0",
+			"/tmp/snap.discord/discord-ipc-0",
+			"/temp/discord-ipc-0",
+			"/temp/app/com.discordapp.Discord/discord-ipc-0",
+			"/temp/snap.discord/discord-ipc-0",
+		}},
+		{"Override", map[string]string{IPCPathEnv: "/foo/discord-ipc-3", "TMPDIR": "/tmp"}, []string{
+			"/foo/discord-ipc-3",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, addr := range candidateSockets(func(k string) string { return tt.env[k] }) {
+				// Only compare the first socket in each directory.
+				if strings.HasSuffix(addr, "-0") || tt.env[IPCPathEnv] != "" {
+					got = append(got, addr)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialError(t *testing.T) {
+	fake, err := newFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+
+	// No sockets exist in the directory.
+	_, err = dialIn(context.Background(), fake.TmpDir)
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("wanted *DialError, got %v", err)
+	}
+	if len(dialErr.Tried) != 10 || !strings.Contains(err.Error(), getDiscordSocket(fake.TmpDir, 9)) {
+		t.Errorf("wanted error listing all 10 sockets, got %v", err)
+	}
+}
+
+func TestDialUntrusted(t *testing.T) {
+	fake, err := newFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+
+	wantUntrusted := func(t *testing.T) {
+		_, err := dialIn(context.Background(), fake.TmpDir)
+		var untrusted *UntrustedSocketError
+		if !errors.As(err, &untrusted) {
+			t.Fatalf("wanted *UntrustedSocketError, got %v", err)
+		}
+		if untrusted.Addr != fake.addr {
+			t.Errorf("wanted %s to be rejected, got %s", fake.addr, untrusted.Addr)
+		}
+	}
+
+	t.Run("NotSocket", func(t *testing.T) {
+		if err := os.WriteFile(fake.addr, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(fake.addr)
+		wantUntrusted(t)
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing the socket's owner requires root")
+		}
+
+		listener, err := fake.Listener()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		if err = os.Chown(fake.addr, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		wantUntrusted(t)
+	})
+}
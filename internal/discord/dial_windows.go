@@ -1,8 +1,9 @@
 package discord
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"os"
 	"time"
 )
 
@@ -25,26 +26,60 @@ func getDiscordNamedPipe(prefix string, n int) string {
 // Instead of using the normal name pipe that Discord uses, it prepends the
 // prefix.  This is useful for testing purposes (to not collide with the real
 // pipe).
-func dialPrefix(prefix string) (*Client, error) {
-	var err error
+func dialPrefix(ctx context.Context, prefix string) (*Client, error) {
+	return dialFirst(ctx, pipesWithPrefix(prefix))
+}
 
+// pipesWithPrefix returns the names of the Discord named pipes that may exist
+// with the given prefix.
+func pipesWithPrefix(prefix string) []string {
 	// Socket may be numbered from 0 to 9.
-	for i := 0; i < 10; i++ {
-		addr := getDiscordNamedPipe(prefix, i)
+	addrs := make([]string, 10)
+	for i := range addrs {
+		addrs[i] = getDiscordNamedPipe(prefix, i)
+	}
+	return addrs
+}
+
+// dialFirst opens the first of the given named pipes that accepts a
+// connection.
+func dialFirst(ctx context.Context, addrs []string) (*Client, error) {
+	dialErr := &DialError{}
+
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		var conn net.Conn
-		timeout := time.Second
-		if conn, err = winio.DialPipe(addr, &timeout); err != nil {
+		pipeCtx, cancel := context.WithTimeout(ctx, time.Second)
+		client, err := DialAddr(pipeCtx, addr)
+		cancel()
+		if err != nil {
+			dialErr.add(addr, err)
 			continue
 		}
 
-		return newClient(conn), nil
+		return client, nil
 	}
 
-	return nil, fmt.Errorf("got errors opening Discord named pipe, last was: %w", err)
+	return nil, dialErr
 }
 
-// Dial opens the Discord socket and returns a client for sending messages.
-func Dial() (*Client, error) {
-	return dialPrefix("")
+// DialAddr opens the Discord named pipe with the given name, rather than
+// searching for it.
+func DialAddr(ctx context.Context, addr string) (*Client, error) {
+	conn, err := winio.DialPipeContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn), nil
+}
+
+// candidates returns the names of the Discord named pipes that may exist, in
+// the order they should be tried.
+func candidates() []string {
+	if addr := os.Getenv(IPCPathEnv); addr != "" {
+		return []string{addr}
+	}
+	return pipesWithPrefix("")
 }
@@ -1,6 +1,7 @@
 package discord
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
@@ -38,7 +39,7 @@ func TestDial(t *testing.T) {
 
 	clientDone := make(chan *Client)
 	go func() {
-		client, err := dialPrefix(prefix)
+		client, err := dialPrefix(context.Background(), prefix)
 		if err != nil {
 			t.Errorf("Got %v while dialing", err)
 		}
@@ -10,35 +10,73 @@
 // The opcode is handled automatically. The first message sent will be assigned
 // a Handshake opcode. Subsequent messages will be assigned the Frame opcode.
 // Pings and close messages (originating from Discord) are handled internally.
+//
+// Discord may also send unsolicited DISPATCH frames for events that were
+// subscribed to with Subscribe().  These are delivered via Events().
 package discord
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
+// IPCPathEnv is the environment variable that overrides the path to Discord's
+// IPC socket (or named pipe, on Windows).  When it's set, no other sockets are
+// tried.
+const IPCPathEnv = "DISCORD_IPC_PATH"
+
 // Payload is the type for Discord's message payload.
 type Payload []byte
 
 // Client is a wrapper for reading and writing to the Discord client via its
 // IPC socket.
 //
-// Outgoing messages can be queued with Send().  They will be processed,
-// one at a time, once Start() has been called.
+// Outgoing messages can be queued with Send().  They will be processed once
+// Start() has been called.
+//
+// By default, the client is lock-step: each message waits for Discord's answer
+// before the next one is sent, and the answer is assumed to be the next frame
+// Discord sends.  Calling Pipeline() before Start() allows many Send() calls
+// to be outstanding at once; each one is answered with the frame whose JSON
+// "nonce" matches its request.
 type Client struct {
-	// out receives payloads that should be sent to Discord.
+	// out receives requests that should be sent to Discord.
 	//
 	// out is read exclusively by the Start() goroutine, and written to
-	// exclusively by Send() and Close().
-	out chan Payload
-
-	// in receives messages that were read from Discord.
-	//
-	// in is written to exclusively by the Start() goroutine, and read
 	// exclusively by Send().
-	in chan message
+	out chan request
+
+	// closing is closed when Close() is called.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// done is closed when Start() returns.
+	done chan struct{}
+
+	// events receives DISPATCH frames that were not answers to a request.
+	// It is written to exclusively by the Start() goroutine, and closed when
+	// Start() returns.
+	events chan Event
+
+	// err is the error Start() returned.  It must not be accessed until done
+	// is closed.
+	err error
+
+	// pipelined enables nonce-correlated requests.  It must not be modified
+	// after Start() is called.
+	pipelined bool
+
+	// maxPayload is the maximum length of a payload read from Discord.  It
+	// must not be modified after Start() is called.
+	maxPayload int
 
 	// rw is the Discord IPC socket.
 	//
@@ -68,80 +106,227 @@ type message struct {
 	Payload Payload
 }
 
+// request is a payload queued by Send(), along with a channel for its answer.
+type request struct {
+	payload Payload
+
+	// reply receives exactly one result.  It must be buffered, so that Start()
+	// never blocks on it.
+	reply chan messageResult
+}
+
+// waiter is a request that has been sent, and is waiting on an answer without
+// a nonce.
+type waiter struct {
+	reply chan messageResult
+
+	// handshake is true if the request was sent with the Handshake opcode.
+	// Discord answers handshakes with a READY DISPATCH.
+	handshake bool
+}
+
+// Event is an unsolicited DISPATCH frame from Discord.
+type Event struct {
+	// Evt is the event name, e.g. "ACTIVITY_JOIN".
+	Evt string
+
+	// Data is the JSON "data" field of the frame.
+	Data json.RawMessage
+
+	// Payload is the entire frame payload.
+	Payload Payload
+}
+
+// eventBufferLen is the number of events that will be queued for Events()
+// before further events are dropped.
+const eventBufferLen = 16
+
+// frameFields are the JSON fields used to route frames from Discord.
+type frameFields struct {
+	Cmd   string          `json:"cmd"`
+	Evt   string          `json:"evt"`
+	Nonce json.RawMessage `json:"nonce"`
+	Data  json.RawMessage `json:"data"`
+}
+
 // newClient creates a Client with the specified IPC socket.
 func newClient(rw io.ReadWriteCloser) *Client {
 	return &Client{
-		// A buffer size of 1 causes Send() to block until the previous message
-		// has an answer.
-		out: make(chan Payload, 1),
-		in:  make(chan message),
-		rw:  rw,
+		out:        make(chan request),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		events:     make(chan Event, eventBufferLen),
+		maxPayload: DefaultMaxPayloadBytes,
+		rw:         rw,
 	}
 }
 
 func (c *Client) close() {
-	close(c.in)
+	close(c.done)
+	close(c.events)
 	c.rw.Close()
-	c.rw = nil
+}
+
+// closedErr returns the error for requests that can't be answered because
+// Start() returned.  It must not be called until done is closed.
+func (c *Client) closedErr() error {
+	if c.err == nil {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Pipeline enables nonce-correlated requests.  It must be called before
+// Start().
+//
+// In pipelined mode, Send() does not wait for the answers to previous
+// requests.  Requests with a JSON "nonce" field are answered with the frame
+// carrying the same nonce.  Requests without a nonce (such as the handshake)
+// are answered, in order, by frames without a nonce.
+func (c *Client) Pipeline() {
+	c.pipelined = true
+}
+
+// SetMaxPayload sets the limit on the length of payloads read from Discord.
+// If Discord sends a longer payload, Start() will return a *ProtocolError.  It
+// must be called before Start().
+func (c *Client) SetMaxPayload(n int) {
+	c.maxPayload = n
+}
+
+// readLoop reads messages from the socket and sends them to readCh, until
+// there is an error or Start() returns.
+func (c *Client) readLoop(readCh chan<- messageResult) {
+	for {
+		msg, err := readMessage(c.rw, c.maxPayload)
+		select {
+		case readCh <- messageResult{msg, err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
 }
 
 // Start waits for messages written with Send(), and sends them to the socket.
 // It will also listen for any messages initiated by Discord.
 func (c *Client) Start() error {
-	defer c.close()
+	return c.StartContext(context.Background())
+}
+
+// StartContext is like Start, but also terminates the connection when ctx is
+// done, in which case it returns ctx.Err().
+func (c *Client) StartContext(ctx context.Context) (err error) {
+	// pending maps nonces to the requests waiting on an answer with that nonce.
+	pending := make(map[string]chan messageResult)
+	// unkeyed is the queue of requests waiting on an answer without a nonce.
+	// In lock-step mode, every request is unkeyed.
+	var unkeyed []waiter
+
+	defer func() {
+		// Any requests still waiting fail with the reason the connection ended.
+		c.err = err
+		failed := messageResult{err: c.closedErr()}
+		for _, reply := range pending {
+			reply <- failed
+		}
+		for _, w := range unkeyed {
+			w.reply <- failed
+		}
+		c.close()
+	}()
+
+	readCh := make(chan messageResult)
+	go c.readLoop(readCh)
 
 	// nextOpcode is the opcode to use for the next packet sent via Send().
 	// The first packet sent will be marked as a Handshake, and subsequent packets
 	// will be marked as Frames.
 	var nextOpcode int32 = Handshake
 
-	// We want to handle events coming from either direction (reads from Discord
-	// or writes from Send()).  Each iteration of the loop reads one message from
-	// Discord, either if there is an unsolicited one waiting, or as a response
-	// to Send().
 	for {
-		readCh := make(chan messageResult, 1)
-		// Will block the for loop from iterating until the read occurs, since one
-		// value from readCh is always read.  Never blocks on the (buffered)
-		// channel.
-		go func() {
-			msg, err := readMessage(c.rw)
-			readCh <- messageResult{msg, err}
-		}()
+		// In lock-step mode, don't accept another request until the previous
+		// one has an answer.
+		out := c.out
+		if !c.pipelined && len(unkeyed) > 0 {
+			out = nil
+		}
 
 		select {
-		case payload, ok := <-c.out:
-			// Got a Send() or Close().
-			if !ok {
-				return nil
-			}
+		case <-c.closing:
+			return nil
+
+		case <-ctx.Done():
+			return ctx.Err()
 
-			if err := writeMessage(message{Opcode: nextOpcode, Payload: payload}, c.rw); err != nil {
+		case req := <-out:
+			// Got a Send().
+			opcode := nextOpcode
+			nonce := payloadNonce(req.payload)
+			keyed := c.pipelined && opcode == Frame && nonce != ""
+			if _, ok := pending[nonce]; keyed && ok {
+				// The answer would be ambiguous, so don't send it at all.
+				req.reply <- messageResult{err: fmt.Errorf("%w: %q", ErrDuplicateNonce, nonce)}
+				continue
+			}
+			if err := writeMessage(message{Opcode: opcode, Payload: req.payload}, c.rw); err != nil {
+				err = &connError{err}
+				req.reply <- messageResult{err: err}
 				return err
 			}
 			nextOpcode = Frame
-			// Block on an answer from Discord.
-			var r messageResult
-			if r = <-readCh; r.err != nil {
-				return r.err
+
+			if keyed {
+				pending[nonce] = req.reply
+			} else {
+				unkeyed = append(unkeyed, waiter{req.reply, opcode == Handshake})
 			}
-			c.in <- r.msg
 
 		case r := <-readCh:
-			// Unsolicited message from Discord.
 			switch {
 			case r.err != nil:
-				return r.err
+				var protocolErr *ProtocolError
+				if errors.As(r.err, &protocolErr) {
+					return r.err
+				}
+				return &connError{r.err}
 			case r.msg.Opcode == Ping:
 				// Respond immediately to ping.
 				r.msg.Opcode = Pong
 				if err := writeMessage(r.msg, c.rw); err != nil {
-					return err
+					return &connError{err}
 				}
+				continue
 			case r.msg.Opcode == Close:
-				return fmt.Errorf("Discord IPC connection terminated by Discord")
+				return parseCloseError(r.msg.Payload)
+			case r.msg.Opcode != Frame && r.msg.Opcode != Handshake:
+				return &ProtocolError{r.msg.Opcode, r.msg.Payload, "unexpected opcode"}
+			}
+
+			var fields frameFields
+			_ = json.Unmarshal(r.msg.Payload, &fields)
+			nonce := rawNonce(fields.Nonce)
+			isDispatch := fields.Cmd == "DISPATCH"
+
+			switch reply, ok := pending[nonce]; {
+			case nonce != "" && ok:
+				delete(pending, nonce)
+				reply <- r
+			case len(unkeyed) > 0 && (unkeyed[0].handshake ||
+				!isDispatch && (!c.pipelined || nonce == "")):
+				unkeyed[0].reply <- r
+				unkeyed = unkeyed[1:]
+			case isDispatch:
+				// Unsolicited event from Discord.  Drop it rather than blocking if
+				// nothing is reading events.
+				select {
+				case c.events <- Event{Evt: fields.Evt, Data: fields.Data, Payload: r.msg.Payload}:
+				default:
+				}
 			default:
-				return fmt.Errorf("got unexpected opcode: %d, payload: %v", r.msg.Opcode, r.msg.Payload)
+				return &ProtocolError{r.msg.Opcode, r.msg.Payload, "unsolicited frame"}
 			}
 		}
 	}
@@ -153,13 +338,35 @@ func (c *Client) Start() error {
 //
 // The returned answer does not include the header used by Discord's IPC
 // protocol; it's just the payload (typically JSON).
+//
+// In pipelined mode, Send may be called concurrently.
 func (c *Client) Send(payload Payload) (Payload, error) {
-	c.out <- payload
+	return c.SendContext(context.Background(), payload)
+}
+
+// SendContext is like Send, but stops waiting when ctx is done, in which case
+// it returns ctx.Err().
+//
+// If the payload was already sent, Discord's answer will be discarded when it
+// arrives.  In lock-step mode, later payloads will still wait for it.
+func (c *Client) SendContext(ctx context.Context, payload Payload) (Payload, error) {
+	req := request{payload: payload, reply: make(chan messageResult, 1)}
+
+	select {
+	case c.out <- req:
+	case <-c.closing:
+		return nil, ErrClosed
+	case <-c.done:
+		return nil, c.closedErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 
-	if msg, ok := <-c.in; !ok {
-		return nil, fmt.Errorf("socket closed while waiting for response")
-	} else {
-		return msg.Payload, nil
+	select {
+	case r := <-req.reply:
+		return r.msg.Payload, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -167,7 +374,91 @@ func (c *Client) Send(payload Payload) (Payload, error) {
 //
 // Send() calls made after Close() will have errors.
 func (c *Client) Close() {
-	close(c.out)
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// Events returns a channel that receives DISPATCH frames from Discord that
+// weren't answers to a request.  The channel is closed when Start() returns.
+//
+// Events are buffered; if the buffer is full, further events are dropped.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Subscribe asks Discord to send DISPATCH frames for the named event (e.g.
+// "ACTIVITY_JOIN"), which will be delivered via Events().  It returns Discord's
+// answer.
+//
+// args are the event arguments, and will be encoded as JSON; many events take
+// none, in which case it may be nil.  Subscribe must be called after the
+// handshake.
+func (c *Client) Subscribe(evt string, args interface{}) (Payload, error) {
+	return c.sendEvtCommand("SUBSCRIBE", evt, args)
+}
+
+// Unsubscribe asks Discord to stop sending DISPATCH frames for the named
+// event.  It returns Discord's answer.
+func (c *Client) Unsubscribe(evt string, args interface{}) (Payload, error) {
+	return c.sendEvtCommand("UNSUBSCRIBE", evt, args)
+}
+
+// sendEvtCommand sends a SUBSCRIBE or UNSUBSCRIBE command.
+func (c *Client) sendEvtCommand(cmd, evt string, args interface{}) (Payload, error) {
+	request := struct {
+		Cmd   string      `json:"cmd"`
+		Evt   string      `json:"evt"`
+		Args  interface{} `json:"args"`
+		Nonce string      `json:"nonce"`
+	}{cmd, evt, args, NewNonce()}
+	if request.Args == nil {
+		request.Args = struct{}{}
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send(payload)
+}
+
+// NewNonce returns a random nonce that won't collide with nonces chosen by
+// other code sharing the client.  Code that sends requests with nonces
+// through a pipelined Client should use it.
+func NewNonce() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// payloadNonce returns the "nonce" field of a JSON payload, or the empty
+// string if the payload has no nonce.
+func payloadNonce(payload Payload) string {
+	var fields frameFields
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return ""
+	}
+	return rawNonce(fields.Nonce)
+}
+
+// rawNonce converts a JSON nonce to a string, or the empty string if the
+// nonce is missing or null.
+//
+// Numeric nonces are returned as their JSON text, so the nonce 2 matches the
+// nonce "2".
+func rawNonce(raw json.RawMessage) string {
+	var s string
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return ""
+	case json.Unmarshal(raw, &s) == nil:
+		return s
+	default:
+		return string(raw)
+	}
 }
 
 // headerLen is the number of bytes in the Discord IPC message header.
@@ -188,26 +479,49 @@ type messageResult struct {
 	err error
 }
 
+// DefaultMaxPayloadBytes is the default limit on the length of a message
+// payload from Discord.  Discord's messages are typically much smaller.
+const DefaultMaxPayloadBytes = 1 << 20
+
 // readMessage reads a message from the socket.
-func readMessage(r io.Reader) (message, error) {
+//
+// It returns a *ProtocolError if the header has an invalid opcode or length,
+// including a payload longer than maxPayload bytes.  No more than the header
+// is read in that case.
+func readMessage(r io.Reader, maxPayload int) (message, error) {
 	var msg message
 
 	header := make([]byte, headerLen)
-	switch n, err := r.Read(header); {
-	case err != nil:
+	if _, err := io.ReadFull(r, header); err != nil {
 		return msg, err
-	case n != headerLen:
-		return msg, fmt.Errorf("wanted %d-byte header, read %d bytes", headerLen, n)
 	}
 
-	reader := bytes.NewReader(header)
-	binary.Read(reader, binary.LittleEndian, &msg.Opcode)
-	var payloadLen int32
-	binary.Read(reader, binary.LittleEndian, &payloadLen)
+	msg.Opcode = int32(binary.LittleEndian.Uint32(header[0:4]))
+	payloadLen := int32(binary.LittleEndian.Uint32(header[4:8]))
+	switch {
+	case msg.Opcode < Handshake || msg.Opcode > Pong:
+		return msg, &ProtocolError{Opcode: msg.Opcode, Reason: "invalid opcode"}
+	case payloadLen < 0:
+		return msg, &ProtocolError{
+			Opcode: msg.Opcode,
+			Reason: fmt.Sprintf("invalid payload length %d", payloadLen),
+		}
+	case int64(payloadLen) > int64(maxPayload):
+		return msg, &ProtocolError{
+			Opcode: msg.Opcode,
+			Reason: fmt.Sprintf("payload length %d exceeds limit of %d", payloadLen, maxPayload),
+		}
+	}
 
 	msg.Payload = make([]byte, payloadLen)
-	_, err := io.ReadFull(r, msg.Payload)
-	return msg, err
+	if _, err := io.ReadFull(r, msg.Payload); err != nil {
+		if err == io.EOF {
+			// The header promised a payload.
+			err = io.ErrUnexpectedEOF
+		}
+		return msg, err
+	}
+	return msg, nil
 }
 
 // writeMessage writes a message to the socket.
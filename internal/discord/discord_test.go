@@ -2,7 +2,13 @@ package discord
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -32,6 +38,11 @@ type fakeConn struct {
 	readBuf []byte
 }
 
+// frame returns the payload in Discord IPC wire format.
+func frame(opcode int32, payload string) []byte {
+	return message{Opcode: opcode, Payload: Payload(payload)}.encode()
+}
+
 func NewFakeConn() *fakeConn {
 	return &fakeConn{
 		WriteCh: make(chan []byte),
@@ -208,11 +219,301 @@ func TestClientServerInitiated(t *testing.T) {
 
 		select {
 		case err := <-startDone:
-			if err == nil {
-				t.Errorf("wanted Start() to return error, got nil")
+			var closeErr *CloseError
+			switch {
+			case !errors.As(err, &closeErr):
+				t.Errorf("wanted Start() to return *CloseError, got %v", err)
+			case closeErr.Code != 1:
+				t.Errorf("wanted code 1, got %d", closeErr.Code)
+			case !errors.Is(err, ErrClosed):
+				t.Errorf("wanted %v to match ErrClosed", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+	})
+
+	t.Run("SendAfterClose", func(t *testing.T) {
+		var closeErr *CloseError
+		if _, err := client.Send([]byte(`{}`)); !errors.As(err, &closeErr) {
+			t.Errorf("wanted Send() to return *CloseError, got %v", err)
+		}
+	})
+}
+
+func TestClientPipelined(t *testing.T) {
+	fakeConn := NewFakeConn()
+	client := newClient(fakeConn)
+	client.Pipeline()
+
+	startDone := make(chan error)
+	go func() {
+		startDone <- client.Start()
+	}()
+
+	type answer struct {
+		payload string
+		err     error
+	}
+
+	// send calls Send() in a new goroutine, and returns a channel that receives
+	// the answer.
+	send := func(payload string) chan answer {
+		ch := make(chan answer, 1)
+		go func() {
+			ans, err := client.Send([]byte(payload))
+			ch <- answer{string(ans), err}
+		}()
+		return ch
+	}
+
+	// wantAnswer waits for an answer from send().
+	wantAnswer := func(t *testing.T, ch chan answer, want string) {
+		select {
+		case got := <-ch:
+			if got.err != nil {
+				t.Error(got.err)
+			} else if got.payload != want {
+				t.Errorf("wanted answer `%s`, got `%s`", want, got.payload)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for answer")
+		}
+	}
+
+	t.Run("Handshake", func(t *testing.T) {
+		ch := send(`{"v":1}`)
+		<-fakeConn.WriteCh
+		fakeConn.ReadCh <- frame(Frame, `{"evt":"READY","nonce":null}`)
+		wantAnswer(t, ch, `{"evt":"READY","nonce":null}`)
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		// Both requests should be written before either is answered.
+		ch1 := send(`{"nonce":"1"}`)
+		<-fakeConn.WriteCh
+		ch2 := send(`{"nonce":2}`)
+		<-fakeConn.WriteCh
+
+		fakeConn.ReadCh <- frame(Frame, `{"nonce":"2","reply":2}`)
+		wantAnswer(t, ch2, `{"nonce":"2","reply":2}`)
+		fakeConn.ReadCh <- frame(Frame, `{"nonce":"1","reply":1}`)
+		wantAnswer(t, ch1, `{"nonce":"1","reply":1}`)
+	})
+
+	t.Run("DuplicateNonce", func(t *testing.T) {
+		ch1 := send(`{"nonce":"5"}`)
+		<-fakeConn.WriteCh
+
+		// The duplicate must fail without being written.
+		select {
+		case got := <-send(`{"nonce":5}`):
+			if !errors.Is(got.err, ErrDuplicateNonce) {
+				t.Errorf("wanted ErrDuplicateNonce, got `%s`, %v", got.payload, got.err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for duplicate to fail")
+		}
+
+		fakeConn.ReadCh <- frame(Frame, `{"nonce":"5"}`)
+		wantAnswer(t, ch1, `{"nonce":"5"}`)
+	})
+
+	t.Run("Unsolicited", func(t *testing.T) {
+		ch := send(`{"nonce":"3"}`)
+		<-fakeConn.WriteCh
+
+		// An unknown nonce must not be mistaken for the reply.
+		fakeConn.ReadCh <- frame(Frame, `{"nonce":"4"}`)
+		select {
+		case err := <-startDone:
+			var protocolErr *ProtocolError
+			if !errors.As(err, &protocolErr) {
+				t.Errorf("wanted Start() to return *ProtocolError, got %v", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+
+		select {
+		case got := <-ch:
+			if got.err == nil {
+				t.Errorf("wanted Send() to return error, got `%s`", got.payload)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Send() to fail")
+		}
+	})
+}
+
+func TestClientEvents(t *testing.T) {
+	fakeConn := NewFakeConn()
+	client := newClient(fakeConn)
+
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.Start()
+	}()
+
+	// The handshake is answered by a READY dispatch.
+	go func() {
+		<-fakeConn.WriteCh
+		fakeConn.ReadCh <- frame(Frame, `{"cmd":"DISPATCH","evt":"READY","nonce":null}`)
+	}()
+	if _, err := client.Send([]byte(`{"v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Subscribe", func(t *testing.T) {
+		done := make(chan int)
+		go func() {
+			buf := <-fakeConn.WriteCh
+			var req struct {
+				Cmd   string `json:"cmd"`
+				Evt   string `json:"evt"`
+				Nonce string `json:"nonce"`
+			}
+			if err := json.Unmarshal(buf[headerLen:], &req); err != nil {
+				t.Error(err)
+			}
+			if req.Cmd != "SUBSCRIBE" || req.Evt != "ACTIVITY_JOIN" || req.Nonce == "" {
+				t.Errorf("got unexpected request %s", buf[headerLen:])
+			}
+
+			// An event arriving before the answer shouldn't be taken as the answer.
+			fakeConn.ReadCh <- frame(Frame, `{"cmd":"DISPATCH","evt":"ACTIVITY_SPECTATE","data":{},"nonce":null}`)
+			fakeConn.ReadCh <- frame(Frame, `{"cmd":"SUBSCRIBE","nonce":"`+req.Nonce+`"}`)
+			done <- 1
+		}()
+
+		ans, err := client.Subscribe("ACTIVITY_JOIN", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(ans, []byte(`"cmd":"SUBSCRIBE"`)) {
+			t.Errorf("got unexpected answer %s", ans)
+		}
+		<-done
+	})
+
+	t.Run("Dispatch", func(t *testing.T) {
+		select {
+		case evt := <-client.Events():
+			if evt.Evt != "ACTIVITY_SPECTATE" {
+				t.Errorf("wanted ACTIVITY_SPECTATE event, got %s", evt.Evt)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for event")
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		client.Close()
+		select {
+		case err := <-startDone:
+			if err != nil {
+				t.Errorf("Start() returned error %v, wanted nil", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+		if _, ok := <-client.Events(); ok {
+			t.Error("wanted Events() to be closed")
+		}
+	})
+}
+
+func TestClientContext(t *testing.T) {
+	fakeConn := NewFakeConn()
+	client := newClient(fakeConn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.StartContext(ctx)
+	}()
+
+	t.Run("SendTimeout", func(t *testing.T) {
+		go func() {
+			// Discord never answers.
+			<-fakeConn.WriteCh
+		}()
+
+		sendCtx, sendCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer sendCancel()
+		if _, err := client.SendContext(sendCtx, []byte(`{}`)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("wanted deadline exceeded, got %v", err)
+		}
+	})
+
+	t.Run("CancelStart", func(t *testing.T) {
+		cancel()
+		select {
+		case err := <-startDone:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Start() returned error %v, wanted %v", err, context.Canceled)
 			}
 		case <-time.After(timeoutSeconds * time.Second):
 			t.Fatal("Timeout waiting for Start() to return")
 		}
 	})
 }
+
+// TestReadMessage checks readMessage against malformed input, including
+// inputs found by FuzzReadMessage (see fuzz_test.go).
+func TestReadMessage(t *testing.T) {
+	const maxPayload = 16
+
+	var tests = []struct {
+		name        string
+		wire        string
+		wantPayload string
+		// wantErr is nil for no error, or an error that the result should match
+		// with errors.Is or errors.As.
+		wantErr interface{}
+	}{
+		{"Valid", "\x01\x00\x00\x00\x02\x00\x00\x00{}", "{}", nil},
+		{"EmptyPayload", "\x03\x00\x00\x00\x00\x00\x00\x00", "", nil},
+		{"MaxPayload", "\x01\x00\x00\x00\x10\x00\x00\x00" + "0123456789abcdef", "0123456789abcdef", nil},
+		{"Empty", "", "", io.EOF},
+		{"ShortHeader", "\x01\x00\x00", "", io.ErrUnexpectedEOF},
+		{"MissingPayload", "\x01\x00\x00\x00\x02\x00\x00\x00", "", io.ErrUnexpectedEOF},
+		{"ShortPayload", "\x01\x00\x00\x00\x02\x00\x00\x00{", "", io.ErrUnexpectedEOF},
+		{"NegativeLength", "\x01\x00\x00\x00\xff\xff\xff\xff", "", new(*ProtocolError)},
+		{"MinLength", "\x01\x00\x00\x00\x00\x00\x00\x80", "", new(*ProtocolError)},
+		{"HugeLength", "\x01\x00\x00\x00\xff\xff\xff\x7f", "", new(*ProtocolError)},
+		{"OverMaxPayload", "\x01\x00\x00\x00\x11\x00\x00\x00" + "0123456789abcdefg", "", new(*ProtocolError)},
+		{"InvalidOpcode", "\x05\x00\x00\x00\x02\x00\x00\x00{}", "", new(*ProtocolError)},
+		{"NegativeOpcode", "\xff\xff\xff\xff\x02\x00\x00\x00{}", "", new(*ProtocolError)},
+		// Found by FuzzReadMessage.
+		{"TextHeader", "00000000", "", new(*ProtocolError)},
+		{"TextLength", "\x00\x00\x00\x00000\x00", "", new(*ProtocolError)},
+		{"MixedLength", "\x00\x00\x00\x000\x02\x00\x00", "", new(*ProtocolError)},
+		{"PingMissingPayload", "\x03\x00\x00\x00\x10\x00\x00\x00", "", io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Deliver one byte per Read() to catch short reads.
+			r := iotest.OneByteReader(strings.NewReader(tt.wire))
+			msg, err := readMessage(r, maxPayload)
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("got error %v", err)
+				} else if string(msg.Payload) != tt.wantPayload {
+					t.Errorf("got payload %q, want %q", msg.Payload, tt.wantPayload)
+				}
+			case error:
+				if !errors.Is(err, want) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			default:
+				if !errors.As(err, want) {
+					t.Errorf("got error %v, want %T", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package discordtest
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// TempDir creates a new temporary directory for servers to listen in.
+func TempDir() (string, error) {
+	return os.MkdirTemp("", "discordtest")
+}
+
+// listen listens on the socket numbered n within dir, like Discord would.
+func listen(dir string, n int) (l net.Listener, addr string, err error) {
+	addr = fmt.Sprintf("%s/discord-ipc-%d", dir, n)
+	l, err = net.Listen("unix", addr)
+	return l, addr, err
+}
+
+// removeDir removes a directory created by TempDir.
+func removeDir(dir string) {
+	os.RemoveAll(dir)
+}
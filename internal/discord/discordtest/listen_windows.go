@@ -0,0 +1,27 @@
+package discordtest
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+import winio "github.com/Microsoft/go-winio"
+
+// TempDir returns a new, unique prefix for named pipes.  There are no
+// directories for named pipes on Windows; instead, the prefix prevents
+// collisions with concurrent tests or real Discord.
+func TempDir() (string, error) {
+	return fmt.Sprintf("discordtest-%d-", time.Now().UnixNano()), nil
+}
+
+// listen listens on the named pipe numbered n with the given prefix, like
+// Discord would.
+func listen(prefix string, n int) (l net.Listener, addr string, err error) {
+	addr = fmt.Sprintf(`\\?\pipe\%sdiscord-ipc-%d`, prefix, n)
+	l, err = winio.ListenPipe(addr, &winio.PipeConfig{})
+	return l, addr, err
+}
+
+// removeDir is a no-op; named pipes are removed when the listener is closed.
+func removeDir(prefix string) {}
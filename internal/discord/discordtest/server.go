@@ -0,0 +1,443 @@
+// Package discordtest provides a fake Discord IPC server for tests.
+//
+// A Server listens on a discord-ipc-N socket in a temporary directory (or a
+// uniquely-prefixed named pipe on Windows).  It answers handshakes with a
+// READY event, and answers frames by echoing their command and nonce.  Tests
+// can customize the answers, inject pings, events, close frames and delays,
+// and inspect every frame the server received.
+package discordtest
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import "github.com/p00ya/chrome-discord-bridge/internal/discord"
+
+// Frame is a message sent over Discord's IPC.
+type Frame struct {
+	Opcode  int32
+	Payload []byte
+}
+
+// Error is returned by a HandlerFunc to answer with an "ERROR" event.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Discord error %d: %s", e.Code, e.Message)
+}
+
+// HandlerFunc computes the data for the answer to a command.  If it returns
+// an *Error, the answer will be an "ERROR" event.
+type HandlerFunc func(cmd string, args json.RawMessage) (data interface{}, err error)
+
+// DefaultReady is the data in the READY event sent in answer to handshakes,
+// unless changed with SetReady.  It mimics the stable Discord client.
+var DefaultReady = map[string]interface{}{
+	"v": 1,
+	"config": map[string]interface{}{
+		"cdn_host":     "cdn.discordapp.com",
+		"api_endpoint": "//discord.com/api",
+		"environment":  "production",
+	},
+	"user": map[string]interface{}{
+		"id":            "1045800378228281345",
+		"username":      "discordtest",
+		"discriminator": "0",
+		"avatar":        nil,
+	},
+}
+
+// Server is a fake Discord IPC server.
+type Server struct {
+	listener net.Listener
+
+	// dir is the directory containing the socket, and addr is its path.
+	dir, addr string
+
+	// ownsDir is true if Close() should remove dir.
+	ownsDir bool
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	handlers map[string]HandlerFunc
+	ready    interface{}
+	delay    time.Duration
+
+	// received contains every frame received, on any connection.
+	received []Frame
+
+	// changed is closed and replaced whenever a frame is received.
+	changed chan struct{}
+
+	// conns are the open connections.
+	conns map[*conn]struct{}
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a server listening on discord-ipc-0 in a new temporary
+// directory.  The directory is removed by Close().
+func NewServer() (*Server, error) {
+	dir, err := TempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewServerAt(dir, 0)
+	if err != nil {
+		removeDir(dir)
+		return nil, err
+	}
+	s.ownsDir = true
+	return s, nil
+}
+
+// NewServerAt starts a server listening on discord-ipc-n in the given
+// directory (from TempDir), which can be used to run several servers in the
+// same directory.
+func NewServerAt(dir string, n int) (*Server, error) {
+	l, addr, err := listen(dir, n)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: l,
+		dir:      dir,
+		addr:     addr,
+		handlers: make(map[string]HandlerFunc),
+		ready:    DefaultReady,
+		changed:  make(chan struct{}),
+		conns:    make(map[*conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Dir returns the directory containing the server's socket (or on Windows,
+// the named pipe prefix).
+func (s *Server) Dir() string {
+	return s.dir
+}
+
+// Addr returns the path to the server's socket (or named pipe).
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Dial connects a new discord.Client to the server.
+func (s *Server) Dial(ctx context.Context) (*discord.Client, error) {
+	return discord.DialAddr(ctx, s.addr)
+}
+
+// Handle sets the handler for answering the given command.  By default,
+// SET_ACTIVITY is answered with the activity, and other commands with null
+// data.
+func (s *Server) Handle(cmd string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[cmd] = h
+}
+
+// SetReady sets the data in the READY event sent in answer to handshakes.
+func (s *Server) SetReady(data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = data
+}
+
+// SetDelay sets how long the server waits before answering each frame.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Received returns every frame received so far, on any connection.
+func (s *Server) Received() []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Frame(nil), s.received...)
+}
+
+// WaitReceived waits until at least n frames have been received, and returns
+// them.
+func (s *Server) WaitReceived(ctx context.Context, n int) ([]Frame, error) {
+	for {
+		s.mu.Lock()
+		received, changed := s.received, s.changed
+		s.mu.Unlock()
+
+		if len(received) >= n {
+			return append([]Frame(nil), received...), nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("got %d frames, wanted %d: %w", len(received), n, ctx.Err())
+		}
+	}
+}
+
+// Ping sends a Ping frame with the given payload on every open connection.
+func (s *Server) Ping(payload []byte) error {
+	return s.broadcast(Frame{discord.Ping, payload})
+}
+
+// Dispatch sends a DISPATCH event on every open connection.
+func (s *Server) Dispatch(evt string, data interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"cmd":   "DISPATCH",
+		"evt":   evt,
+		"data":  data,
+		"nonce": nil,
+	})
+	if err != nil {
+		return err
+	}
+	return s.broadcast(Frame{discord.Frame, payload})
+}
+
+// SendClose sends a Close frame with the given code and message on every open
+// connection, and then closes them.
+func (s *Server) SendClose(code int, message string) error {
+	payload, err := json.Marshal(Error{code, message})
+	if err != nil {
+		return err
+	}
+	err = s.broadcast(Frame{discord.Close, payload})
+	s.Disconnect()
+	return err
+}
+
+// Disconnect abruptly closes every open connection, as if Discord crashed.
+// The server continues to accept new connections.
+func (s *Server) Disconnect() {
+	for _, c := range s.openConns() {
+		c.Close()
+	}
+}
+
+// Close stops the server and closes every open connection.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Disconnect()
+	s.wg.Wait()
+	if s.ownsDir {
+		removeDir(s.dir)
+	}
+}
+
+func (s *Server) openConns() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (s *Server) broadcast(f Frame) error {
+	for _, c := range s.openConns() {
+		if err := c.write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept serves connections until the listener is closed.
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{Conn: nc}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(c)
+			c.Close()
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// record appends a received frame.
+func (s *Server) record(f Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, f)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// serve reads frames from a connection and answers them, until the
+// connection is closed.
+func (s *Server) serve(c *conn) {
+	for {
+		f, err := readFrame(c)
+		if err != nil {
+			return
+		}
+		s.record(f)
+
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		var answer []byte
+		switch f.Opcode {
+		case discord.Handshake:
+			answer, err = s.readyPayload()
+		case discord.Frame:
+			answer, err = s.answer(f.Payload)
+		case discord.Close:
+			return
+		default:
+			// Pongs need no answer.
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+		if err = c.write(Frame{discord.Frame, answer}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) readyPayload() ([]byte, error) {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+
+	return json.Marshal(map[string]interface{}{
+		"cmd":   "DISPATCH",
+		"evt":   "READY",
+		"data":  ready,
+		"nonce": nil,
+	})
+}
+
+// answer computes the answer to a request frame.
+func (s *Server) answer(payload []byte) ([]byte, error) {
+	var req struct {
+		Cmd   string          `json:"cmd"`
+		Args  json.RawMessage `json:"args"`
+		Evt   string          `json:"evt"`
+		Nonce json.RawMessage `json:"nonce"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	h, ok := s.handlers[req.Cmd]
+	s.mu.Unlock()
+	if !ok {
+		h = defaultHandler
+	}
+
+	response := map[string]interface{}{
+		"cmd":   req.Cmd,
+		"evt":   nil,
+		"nonce": req.Nonce,
+	}
+	if req.Evt != "" {
+		response["evt"] = req.Evt
+	}
+
+	data, err := h(req.Cmd, req.Args)
+	if e, ok := err.(*Error); ok {
+		response["evt"] = "ERROR"
+		data = e
+	} else if err != nil {
+		return nil, err
+	}
+	response["data"] = data
+
+	return json.Marshal(response)
+}
+
+// defaultHandler answers SET_ACTIVITY with the activity, and other commands
+// with null data.
+func defaultHandler(cmd string, args json.RawMessage) (interface{}, error) {
+	if cmd != "SET_ACTIVITY" {
+		return nil, nil
+	}
+
+	var a struct {
+		Activity json.RawMessage `json:"activity"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	return a.Activity, nil
+}
+
+// conn is a connection to the server.  Writes are serialized so that frames
+// can be injected concurrently with answers.
+type conn struct {
+	net.Conn
+	writeMu sync.Mutex
+}
+
+func (c *conn) write(f Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Write(f.encode())
+	return err
+}
+
+// headerLen is the number of bytes in the Discord IPC message header.
+const headerLen = 8
+
+// encode serializes the frame in wire format.
+func (f Frame) encode() []byte {
+	buf := make([]byte, headerLen+len(f.Payload))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(f.Opcode))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(f.Payload)))
+	copy(buf[headerLen:], f.Payload)
+	return buf
+}
+
+// readFrame reads a frame in wire format.
+func readFrame(r io.Reader) (Frame, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Frame{}, err
+	}
+
+	f := Frame{Opcode: int32(binary.LittleEndian.Uint32(header[0:]))}
+	f.Payload = make([]byte, binary.LittleEndian.Uint32(header[4:]))
+	_, err := io.ReadFull(r, f.Payload)
+	return f, err
+}
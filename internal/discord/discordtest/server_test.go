@@ -0,0 +1,115 @@
+package discordtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord/rpc"
+)
+
+// Number of seconds to wait for things that should be near-instantaneous.
+const timeoutSeconds = 2
+
+func TestServer(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutSeconds*time.Second)
+	defer cancel()
+
+	client, err := server.Dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.Start()
+	}()
+	rpcClient := rpc.NewClient(client)
+
+	t.Run("Handshake", func(t *testing.T) {
+		ready, err := rpcClient.Handshake(ctx, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready.User.Username != "discordtest" {
+			t.Errorf("got unexpected READY data %+v", ready)
+		}
+	})
+
+	t.Run("SetActivity", func(t *testing.T) {
+		server.SetDelay(time.Millisecond)
+		defer server.SetDelay(0)
+
+		activity, err := rpcClient.SetActivity(ctx, 1, &rpc.Activity{State: "testing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if activity.State != "testing" {
+			t.Errorf("got unexpected activity %+v", activity)
+		}
+	})
+
+	t.Run("Handle", func(t *testing.T) {
+		server.Handle(rpc.CmdSubscribe, func(cmd string, args json.RawMessage) (interface{}, error) {
+			return nil, &Error{4006, "Not authenticated or invalid scope"}
+		})
+
+		err := rpcClient.Subscribe(ctx, rpc.EvtActivityJoin, nil)
+		var rpcErr *rpc.Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != 4006 {
+			t.Errorf("wanted error 4006, got %v", err)
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		n := len(server.Received())
+		if err := server.Ping([]byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		received, err := server.WaitReceived(ctx, n+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pong := received[n]; pong.Opcode != discord.Pong {
+			t.Errorf("wanted Pong, got opcode %d", pong.Opcode)
+		}
+	})
+
+	t.Run("Dispatch", func(t *testing.T) {
+		if err := server.Dispatch(rpc.EvtActivityJoin, map[string]string{"secret": "s"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case evt := <-client.Events():
+			if evt.Evt != rpc.EvtActivityJoin {
+				t.Errorf("wanted %s, got %s", rpc.EvtActivityJoin, evt.Evt)
+			}
+		case <-ctx.Done():
+			t.Fatal("Timeout waiting for event")
+		}
+	})
+
+	t.Run("SendClose", func(t *testing.T) {
+		if err := server.SendClose(4000, "Invalid Client ID"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-startDone:
+			if err == nil {
+				t.Error("wanted Start() to return error, got nil")
+			}
+		case <-ctx.Done():
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+	})
+}
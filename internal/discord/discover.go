@@ -0,0 +1,185 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Instance is a running Discord client that accepted a connection.
+//
+// Each release channel of Discord (and each logged-in account) listens on a
+// different socket.
+type Instance struct {
+	// Addr is the path to the instance's socket (or named pipe).
+	Addr string
+
+	// User is the logged-in user.
+	User User
+
+	// Config describes the instance's environment.
+	Config Config
+
+	// ReleaseChannel is "stable", "ptb" or "canary".
+	ReleaseChannel string
+}
+
+// User is a Discord user, as described in the READY event.
+type User struct {
+	Id            string `json:"id"`
+	Username      string `json:"username"`
+	Discriminator string `json:"discriminator"`
+	Avatar        string `json:"avatar"`
+}
+
+// Config describes the Discord environment, as described in the READY event.
+type Config struct {
+	CdnHost     string `json:"cdn_host"`
+	ApiEndpoint string `json:"api_endpoint"`
+	Environment string `json:"environment"`
+}
+
+// Selector chooses which Discord instance to connect to.
+type Selector func(Instance) bool
+
+// ByUser selects the instance logged in as the user with the given ID.
+func ByUser(id string) Selector {
+	return func(i Instance) bool {
+		return i.User.Id == id
+	}
+}
+
+// ByReleaseChannel selects the instance for the given release channel, e.g.
+// "canary".
+func ByReleaseChannel(channel string) Selector {
+	return func(i Instance) bool {
+		return i.ReleaseChannel == channel
+	}
+}
+
+// DiscoverClientId is the application ID used to handshake with each instance
+// during discovery.  It's the ID of the chrome-discord-bridge development
+// application; it isn't used to set any activity.
+const DiscoverClientId = "922040684020645908"
+
+// discoverTimeout is how long to wait for each instance to answer the
+// handshake during discovery.
+const discoverTimeout = time.Second
+
+// Discover probes every candidate Discord socket and returns the instances
+// that answered a handshake, in the order Dial would try them.
+func Discover(ctx context.Context) ([]Instance, error) {
+	return discover(ctx, candidates())
+}
+
+// discover probes each of the sockets at addrs.
+func discover(ctx context.Context, addrs []string) ([]Instance, error) {
+	var instances []Instance
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if instance, err := probe(ctx, addr); err == nil {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// probe connects to the socket at addr and handshakes with it.
+func probe(ctx context.Context, addr string) (Instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+
+	client, err := DialAddr(ctx, addr)
+	if err != nil {
+		return Instance{}, err
+	}
+	go client.StartContext(ctx)
+	defer client.Close()
+
+	handshake, err := json.Marshal(struct {
+		Version  int    `json:"v"`
+		ClientId string `json:"client_id"`
+	}{1, DiscoverClientId})
+	if err != nil {
+		return Instance{}, err
+	}
+
+	answer, err := client.SendContext(ctx, handshake)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	var ready struct {
+		Evt  string `json:"evt"`
+		Data struct {
+			Config Config `json:"config"`
+			User   User   `json:"user"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(answer, &ready); err != nil {
+		return Instance{}, err
+	}
+	if ready.Evt != "READY" {
+		return Instance{}, fmt.Errorf("wanted READY event, got %s", answer)
+	}
+
+	return Instance{
+		Addr:           addr,
+		User:           ready.Data.User,
+		Config:         ready.Data.Config,
+		ReleaseChannel: releaseChannel(ready.Data.Config.ApiEndpoint),
+	}, nil
+}
+
+// releaseChannel infers the release channel from the instance's API endpoint,
+// e.g. "//canary.discord.com/api".
+func releaseChannel(apiEndpoint string) string {
+	host := strings.TrimPrefix(apiEndpoint, "//")
+	for _, channel := range []string{"canary", "ptb"} {
+		if strings.HasPrefix(host, channel+".") {
+			return channel
+		}
+	}
+	return "stable"
+}
+
+// Dial opens the Discord socket and returns a client for sending messages.
+//
+// If selectors are given, Dial connects to the first instance (as returned by
+// Discover) that matches all of them.  Otherwise, it connects to the first
+// socket that accepts a connection.
+func Dial(selectors ...Selector) (*Client, error) {
+	return DialContext(context.Background(), selectors...)
+}
+
+// DialContext is like Dial, but gives up when ctx is done.
+func DialContext(ctx context.Context, selectors ...Selector) (*Client, error) {
+	return dialContext(ctx, candidates(), selectors...)
+}
+
+// dialContext is like DialContext, but only tries the sockets at addrs.
+func dialContext(ctx context.Context, addrs []string, selectors ...Selector) (*Client, error) {
+	if len(selectors) == 0 {
+		return dialFirst(ctx, addrs)
+	}
+
+	instances, err := discover(ctx, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+outer:
+	for _, instance := range instances {
+		for _, selected := range selectors {
+			if !selected(instance) {
+				continue outer
+			}
+		}
+		return DialAddr(ctx, instance.Addr)
+	}
+	return nil, fmt.Errorf("%w: none of the %d Discord instances found matched", ErrNoInstance, len(instances))
+}
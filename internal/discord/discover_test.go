@@ -0,0 +1,92 @@
+//go:build !windows
+
+package discord_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/p00ya/chrome-discord-bridge/internal/discord"
+	"github.com/p00ya/chrome-discord-bridge/internal/discord/discordtest"
+)
+
+// Number of seconds to wait for things that should be near-instantaneous.
+const timeoutSeconds = 2
+
+func TestDiscover(t *testing.T) {
+	dir, err := discordtest.TempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate Discord stable and canary, logged in as different users.
+	stable, err := discordtest.NewServerAt(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stable.Close)
+
+	canary, err := discordtest.NewServerAt(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(canary.Close)
+	canary.SetReady(map[string]interface{}{
+		"v":      1,
+		"config": map[string]string{"api_endpoint": "//canary.discord.com/api"},
+		"user":   map[string]string{"id": "2", "username": "canary"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutSeconds*time.Second)
+	defer cancel()
+
+	// Only try the fake instances, not any real Discord that's running.
+	addrs := []string{stable.Addr(), canary.Addr()}
+
+	t.Run("Discover", func(t *testing.T) {
+		instances, err := discord.DiscoverAddrs(ctx, addrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(instances) != 2 {
+			t.Fatalf("wanted 2 instances, got %+v", instances)
+		}
+		if instances[0].ReleaseChannel != "stable" || instances[1].ReleaseChannel != "canary" {
+			t.Errorf("got unexpected release channels %+v", instances)
+		}
+		if instances[1].User.Username != "canary" {
+			t.Errorf("got unexpected user %+v", instances[1].User)
+		}
+	})
+
+	t.Run("DialSelector", func(t *testing.T) {
+		client, err := discord.DialContextAddrs(ctx, addrs, discord.ByUser("2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go client.StartContext(ctx)
+		defer client.Close()
+
+		if _, err = client.SendContext(ctx, []byte(`{"v":1,"client_id":"1"}`)); err != nil {
+			t.Fatal(err)
+		}
+		// Discovery also sends a handshake, so check the most recent frame.
+		received := canary.Received()
+		if last := received[len(received)-1]; !bytes.Contains(last.Payload, []byte(`"client_id":"1"`)) {
+			t.Errorf("wanted handshake to be sent to canary, got %s", last.Payload)
+		}
+	})
+
+	t.Run("DialNoMatch", func(t *testing.T) {
+		_, err := discord.DialContextAddrs(ctx, addrs, discord.ByUser("3"))
+		if !errors.Is(err, discord.ErrNoInstance) {
+			t.Errorf("wanted ErrNoInstance, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UntrustedSocketError is returned when a Discord socket was rejected because
+// it might not belong to Discord, e.g. because it's owned by another user.
+type UntrustedSocketError struct {
+	Addr   string
+	Reason string
+}
+
+func (e *UntrustedSocketError) Error() string {
+	return fmt.Sprintf("rejected untrusted Discord socket %s: %s", e.Addr, e.Reason)
+}
+
+// ErrClosed is returned when the connection to Discord has been closed, by
+// either end.  Errors describing why Discord closed the connection (such as
+// *CloseError) match ErrClosed with errors.Is().
+var ErrClosed = errors.New("Discord IPC connection closed")
+
+// ErrDuplicateNonce is returned by Send() in pipelined mode for a payload
+// whose nonce is the same as a request still waiting on an answer.
+var ErrDuplicateNonce = errors.New("nonce is already waiting on an answer")
+
+// CloseError is returned when Discord terminates the connection with a Close
+// message, e.g. because the client ID was invalid.
+type CloseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes that Discord is known to close connections with.
+const (
+	CloseNormal          = 1000
+	CloseInvalidClientId = 4000
+	CloseInvalidOrigin   = 4001
+	CloseRateLimited     = 4002
+	CloseTokenRevoked    = 4003
+	CloseInvalidVersion  = 4004
+	CloseInvalidEncoding = 4005
+)
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("Discord IPC connection terminated by Discord: %s (code %d)", e.Message, e.Code)
+}
+
+// RejectsHandshake returns true if the close code means Discord rejected the
+// handshake, so that sending the same handshake again won't help.  Other codes
+// (e.g. CloseRateLimited) are transient.
+func (e *CloseError) RejectsHandshake() bool {
+	switch e.Code {
+	case CloseInvalidClientId, CloseInvalidOrigin, CloseInvalidVersion, CloseInvalidEncoding:
+		return true
+	}
+	return false
+}
+
+// Is makes CloseError match ErrClosed.
+func (e *CloseError) Is(target error) bool {
+	return target == ErrClosed
+}
+
+// parseCloseError decodes the payload of a Close message.
+func parseCloseError(payload Payload) *CloseError {
+	var e CloseError
+	if err := json.Unmarshal(payload, &e); err != nil {
+		e.Message = string(payload)
+	}
+	return &e
+}
+
+// ProtocolError is returned when Discord sends a message that doesn't follow
+// the IPC protocol.
+type ProtocolError struct {
+	Opcode  int32
+	Payload Payload
+	Reason  string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("Discord IPC protocol error: %s (opcode %d, payload %q)", e.Reason, e.Opcode, e.Payload)
+}
+
+// connError is returned when reading from or writing to the Discord socket
+// fails, e.g. because Discord exited.  It matches ErrClosed with errors.Is().
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return fmt.Sprintf("Discord IPC connection lost: %v", e.err)
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+func (e *connError) Is(target error) bool {
+	return target == ErrClosed
+}
+
+// ErrNoInstance is returned by Dial when Discord instances were found, but none
+// matched the selectors.
+var ErrNoInstance = errors.New("no matching Discord instance")
+
+// DialError is returned when none of the candidate Discord sockets could be
+// opened.
+type DialError struct {
+	// Tried are the sockets that were tried, in order.
+	Tried []string
+
+	// Err is the first *UntrustedSocketError if any sockets were rejected, or
+	// otherwise the error from the last socket tried.
+	Err error
+}
+
+// add records the error from trying a socket.
+func (e *DialError) add(addr string, err error) {
+	e.Tried = append(e.Tried, addr)
+
+	var untrusted *UntrustedSocketError
+	if errors.As(e.Err, &untrusted) {
+		return
+	}
+	e.Err = err
+}
+
+func (e *DialError) Error() string {
+	if len(e.Tried) == 0 {
+		return "no Discord sockets to try"
+	}
+	return fmt.Sprintf("got errors opening Discord sockets: %v; tried: %s",
+		e.Err, strings.Join(e.Tried, ", "))
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,8 @@
+package discord
+
+// Exported for tests in package discord_test, which can't be in package
+// discord because discordtest imports it.
+var (
+	DiscoverAddrs    = discover
+	DialContextAddrs = dialContext
+)
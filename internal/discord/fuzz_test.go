@@ -0,0 +1,38 @@
+//go:build go1.18
+
+package discord
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzReadMessage checks that readMessage never panics or over-allocates, and
+// that any message it accepts encodes back to the bytes it read.
+//
+// Interesting inputs it finds should be added to TestReadMessage, which runs
+// on older versions of Go too.
+func FuzzReadMessage(f *testing.F) {
+	const maxPayload = 16
+
+	f.Add([]byte("\x01\x00\x00\x00\x02\x00\x00\x00{}"))
+	f.Add([]byte("\x03\x00\x00\x00\x00\x00\x00\x00"))
+	f.Add([]byte("\x01\x00\x00\x00\xff\xff\xff\xff"))
+	f.Add([]byte("\x05\x00\x00\x00\x02\x00\x00\x00{}"))
+
+	f.Fuzz(func(t *testing.T, wire []byte) {
+		msg, err := readMessage(bytes.NewReader(wire), maxPayload)
+		if err != nil {
+			return
+		}
+		if msg.Opcode < Handshake || msg.Opcode > Pong {
+			t.Errorf("accepted invalid opcode %d", msg.Opcode)
+		}
+		if len(msg.Payload) > maxPayload {
+			t.Errorf("accepted %d-byte payload, over limit of %d", len(msg.Payload), maxPayload)
+		}
+		if encoded := msg.encode(); !bytes.HasPrefix(wire, encoded) {
+			t.Errorf("message %+v encodes to %q, not a prefix of %q", msg, encoded, wire)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package discord
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPeer verifies that the process at the other end of the socket is owned
+// by the current user, using SO_PEERCRED.
+func checkPeer(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return fmt.Errorf("getting peer credentials: %w", err)
+	}
+
+	if uid := os.Getuid(); int(cred.Uid) != uid {
+		return fmt.Errorf("peer process %d is owned by UID %d, not %d", cred.Pid, cred.Uid, uid)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package discord
+
+import "net"
+
+// checkPeer is a no-op; peer credentials aren't available through the
+// standard library on this platform.  The socket file's owner is still
+// checked.
+func checkPeer(conn *net.UnixConn) error {
+	return nil
+}
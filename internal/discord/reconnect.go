@@ -0,0 +1,445 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backoff configures the delays between reconnection attempts.
+//
+// The first retry happens after Initial, and each subsequent delay is
+// multiplied by Multiplier, up to Max.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// next returns the delay to use after the given delay.
+func (b Backoff) next(d time.Duration) time.Duration {
+	d = time.Duration(float64(d) * b.Multiplier)
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// ReconnectOptions configures a ReconnectingClient.  Zero values are replaced
+// with defaults.
+type ReconnectOptions struct {
+	// Backoff is the delay between attempts to reconnect.
+	Backoff Backoff
+
+	// Deadline is how long reconnecting may keep failing before the
+	// ReconnectingClient gives up.  If negative, it never gives up.
+	Deadline time.Duration
+
+	// Dial opens a new connection to Discord.  Defaults to DialContext.
+	Dial func(ctx context.Context) (*Client, error)
+}
+
+// Default values for ReconnectOptions.
+const (
+	defaultBackoffInitial    = 500 * time.Millisecond
+	defaultBackoffMax        = 10 * time.Second
+	defaultBackoffMultiplier = 2
+	defaultDeadline          = time.Minute
+)
+
+// minHealthyConnection is how long a connection must last for the backoff to
+// start again from scratch.  Otherwise, a Discord that accepts connections and
+// then immediately closes them (e.g. because of rate limiting) would be
+// redialled in a tight loop.
+const minHealthyConnection = 10 * time.Second
+
+func (o ReconnectOptions) withDefaults() ReconnectOptions {
+	if o.Backoff.Initial <= 0 {
+		o.Backoff.Initial = defaultBackoffInitial
+	}
+	if o.Backoff.Max <= 0 {
+		o.Backoff.Max = defaultBackoffMax
+	}
+	if o.Backoff.Multiplier < 1 {
+		o.Backoff.Multiplier = defaultBackoffMultiplier
+	}
+	if o.Deadline == 0 {
+		o.Deadline = defaultDeadline
+	}
+	if o.Dial == nil {
+		o.Dial = func(ctx context.Context) (*Client, error) {
+			return DialContext(ctx)
+		}
+	}
+	return o
+}
+
+// ReconnectingClient is a Client that transparently reconnects to Discord if
+// the connection is lost (e.g. because Discord restarted).
+//
+// The first payload sent is remembered as the handshake, and the last
+// SET_ACTIVITY payload is remembered too.  After reconnecting, both are
+// replayed on the new connection before any other payloads are sent.
+//
+// Create a ReconnectingClient with NewReconnectingClient(), and run Start() to
+// connect.  Send() calls are serialized.
+type ReconnectingClient struct {
+	opts ReconnectOptions
+
+	// sendMu is held while a payload is being sent or replayed.
+	sendMu sync.Mutex
+
+	// handshake is the first payload sent, and handshakeAnswer is the answer
+	// to its most recent replay.  Guarded by sendMu.
+	handshake, handshakeAnswer Payload
+
+	// activity is the last SET_ACTIVITY payload Discord accepted.  Guarded by
+	// sendMu.
+	activity Payload
+
+	// rejected is the error from Discord rejecting the replayed handshake,
+	// which is returned by the next Send().  Guarded by sendMu.
+	rejected error
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// client is the current connection, or nil while disconnected.
+	client *Client
+
+	// err is set once the ReconnectingClient has given up or been closed.
+	err error
+
+	// lastErr is the error from the most recent failed attempt to connect,
+	// or nil while connected.
+	lastErr error
+
+	// redial is set by Reset() so that the next connection is dialled
+	// without waiting.
+	redial bool
+
+	// changed is closed and replaced whenever client or err change.
+	changed chan struct{}
+
+	// closing is closed when Close() is called.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReconnectingClient creates a ReconnectingClient.  It won't connect to
+// Discord until Start() is called.
+func NewReconnectingClient(opts ReconnectOptions) *ReconnectingClient {
+	return &ReconnectingClient{
+		opts:    opts.withDefaults(),
+		changed: make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// setState updates the current connection and error, and wakes up any Send()
+// calls waiting on them.
+func (r *ReconnectingClient) setState(client *Client, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client, r.err = client, err
+	if client != nil {
+		r.lastErr = nil
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Start connects to Discord, and reconnects whenever the connection is lost.
+//
+// Start returns nil after Close() is called, or an error if it was unable to
+// reconnect before the deadline.
+func (r *ReconnectingClient) Start() error {
+	return r.StartContext(context.Background())
+}
+
+// StartContext is like Start, but also terminates the connection and stops
+// reconnecting when ctx is done, in which case it returns ctx.Err().
+func (r *ReconnectingClient) StartContext(ctx context.Context) error {
+	brokenSince := time.Now()
+	delay := r.opts.Backoff.Initial
+
+	for {
+		client, err := r.opts.Dial(ctx)
+		if err == nil {
+			connected := time.Now()
+			if err = r.run(ctx, client); err == nil && time.Since(connected) >= minHealthyConnection {
+				// The connection was healthy until it was lost; start backing off
+				// again from scratch.
+				brokenSince = time.Now()
+				delay = r.opts.Backoff.Initial
+			}
+		}
+
+		select {
+		case <-r.closing:
+			r.setState(nil, ErrClosed)
+			return nil
+		case <-ctx.Done():
+			r.setState(nil, ctx.Err())
+			return ctx.Err()
+		default:
+		}
+
+		if err != nil {
+			r.mu.Lock()
+			r.lastErr = err
+			r.mu.Unlock()
+			if r.opts.Deadline > 0 && time.Now().Add(delay).After(brokenSince.Add(r.opts.Deadline)) {
+				err = fmt.Errorf("reconnecting to Discord: %w", err)
+				r.setState(nil, err)
+				return err
+			}
+		}
+
+		r.mu.Lock()
+		redial := r.redial
+		r.redial = false
+		r.mu.Unlock()
+		if redial {
+			// Reset() dropped the connection deliberately.
+			delay = r.opts.Backoff.Initial
+			continue
+		}
+
+		// Even after a successful connection, wait before redialling, in case
+		// Discord closed it straight away.
+		select {
+		case <-time.After(delay):
+		case <-r.closing:
+			r.setState(nil, ErrClosed)
+			return nil
+		case <-ctx.Done():
+			r.setState(nil, ctx.Err())
+			return ctx.Err()
+		}
+		delay = r.opts.Backoff.next(delay)
+	}
+}
+
+// run replays the handshake and activity on a new connection, and then makes
+// it available to Send() until the connection is lost, ctx is done or Close()
+// is called.
+//
+// It returns an error if the replay failed.
+func (r *ReconnectingClient) run(ctx context.Context, client *Client) error {
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.StartContext(ctx)
+	}()
+
+	r.sendMu.Lock()
+	err := r.replay(ctx, client)
+	r.sendMu.Unlock()
+
+	if err == nil {
+		r.setState(client, nil)
+		select {
+		case <-startDone:
+		case <-r.closing:
+		}
+		r.setState(nil, nil)
+	}
+
+	client.Close()
+	return err
+}
+
+// replay sends the remembered handshake and activity on a new connection.
+// The caller must hold sendMu.
+//
+// If Discord rejects the handshake, it's forgotten (so that it isn't replayed
+// again), and the error is kept for the next Send().  Other errors, including
+// closes that don't reject the handshake (e.g. rate limiting), are transient
+// and will be retried after backing off.
+func (r *ReconnectingClient) replay(ctx context.Context, client *Client) error {
+	if r.handshake == nil {
+		return nil
+	}
+
+	answer, err := client.SendContext(ctx, r.handshake)
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) && closeErr.RejectsHandshake() {
+		r.handshake, r.handshakeAnswer, r.activity = nil, nil, nil
+		r.rejected = err
+	}
+	if err != nil {
+		return err
+	}
+	r.handshakeAnswer = answer
+
+	if r.activity != nil {
+		_, err = client.SendContext(ctx, r.activity)
+	}
+	return err
+}
+
+// current waits for a connection other than prev, and returns it.
+func (r *ReconnectingClient) current(ctx context.Context, prev *Client) (*Client, error) {
+	for {
+		r.mu.Lock()
+		client, err, changed := r.client, r.err, r.changed
+		r.mu.Unlock()
+
+		switch {
+		case err != nil:
+			return nil, err
+		case client != nil && client != prev:
+			return client, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WaitConnected waits until the ReconnectingClient is connected to Discord.
+// It returns an error if the ReconnectingClient gave up or was closed, or
+// ctx.Err() if ctx is done first.
+func (r *ReconnectingClient) WaitConnected(ctx context.Context) error {
+	_, err := r.current(ctx, nil)
+	return err
+}
+
+// LastError returns the error from the most recent failed attempt to connect
+// to Discord, or nil if the ReconnectingClient is connected (or hasn't tried
+// yet).
+func (r *ReconnectingClient) LastError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// Send sends the given payload to Discord and returns the answer payload,
+// like Client.Send().
+//
+// If the connection is lost, Send waits for Discord to be reconnected and
+// retries.  It returns an error only if the ReconnectingClient gave up or
+// was closed.
+func (r *ReconnectingClient) Send(payload Payload) (Payload, error) {
+	return r.SendContext(context.Background(), payload)
+}
+
+// SendContext is like Send, but stops waiting (and retrying) when ctx is done,
+// in which case it returns ctx.Err().
+func (r *ReconnectingClient) SendContext(ctx context.Context, payload Payload) (Payload, error) {
+	var prev *Client
+	for {
+		client, err := r.current(ctx, prev)
+		if err != nil {
+			return nil, err
+		}
+
+		r.sendMu.Lock()
+		if r.rejected != nil {
+			// The extension's handshake is no longer valid, so the payload
+			// can't be sent until it handshakes again.
+			err := r.rejected
+			r.rejected = nil
+			r.sendMu.Unlock()
+			return nil, err
+		}
+		if r.handshake == nil {
+			r.handshake = payload
+		} else if prev != nil && isSameHandshake(payload, r.handshake) {
+			// The handshake was already replayed on the new connection.
+			answer := r.handshakeAnswer
+			r.sendMu.Unlock()
+			return answer, nil
+		}
+
+		answer, err := client.SendContext(ctx, payload)
+		var closeErr *CloseError
+		switch {
+		case err == nil && isSetActivity(payload) && !isError(answer):
+			r.activity = payload
+		case errors.As(err, &closeErr) && closeErr.RejectsHandshake() && isSameHandshake(payload, r.handshake):
+			// Discord rejected the handshake (e.g. because the client ID was
+			// invalid), so don't replay it.
+			r.handshake, r.activity = nil, nil
+		}
+		r.sendMu.Unlock()
+
+		switch {
+		case err == nil:
+			return answer, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case closeErr != nil:
+			// Discord closed the connection deliberately; retrying the same
+			// payload won't help.
+			return nil, err
+		case errors.Is(err, ErrDuplicateNonce):
+			return nil, err
+		}
+		prev = client
+	}
+}
+
+// Reset forgets the handshake and activity, and drops the current
+// connection (if any), so that the next payload sent is the handshake on a new
+// connection.  It can be used to switch application IDs.
+func (r *ReconnectingClient) Reset() {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	r.handshake, r.handshakeAnswer, r.activity, r.rejected = nil, nil, nil, nil
+
+	r.mu.Lock()
+	client := r.client
+	r.redial = client != nil
+	r.mu.Unlock()
+	if client != nil {
+		// Stop Send() from using the old connection before run() notices that
+		// it's closed.
+		r.setState(nil, nil)
+		client.Close()
+	}
+}
+
+// HasHandshake returns true if a handshake has been sent and not rejected by
+// Discord, i.e. the next payload sent won't be treated as the handshake.
+func (r *ReconnectingClient) HasHandshake() bool {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	return r.handshake != nil
+}
+
+// Close terminates the connection to Discord, and stops reconnecting.
+func (r *ReconnectingClient) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+	})
+}
+
+// isSameHandshake returns true if payload is the remembered handshake.
+func isSameHandshake(payload, handshake Payload) bool {
+	return string(payload) == string(handshake)
+}
+
+// isError returns true if the answer is an "ERROR" event, i.e. Discord
+// rejected the command.
+func isError(answer Payload) bool {
+	var fields frameFields
+	if err := json.Unmarshal(answer, &fields); err != nil {
+		return false
+	}
+	return fields.Evt == "ERROR"
+}
+
+// isSetActivity returns true if the payload is a SET_ACTIVITY command.
+func isSetActivity(payload Payload) bool {
+	var fields frameFields
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return false
+	}
+	return fields.Cmd == "SET_ACTIVITY"
+}
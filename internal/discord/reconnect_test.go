@@ -0,0 +1,347 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnectingClient(t *testing.T) {
+	conns := make(chan *fakeConn)
+	client := NewReconnectingClient(ReconnectOptions{
+		Backoff: Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Dial: func(ctx context.Context) (*Client, error) {
+			conn := NewFakeConn()
+			conns <- conn
+			return newClient(conn), nil
+		},
+	})
+
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.Start()
+	}()
+
+	handshake := []byte(`{"v":1,"client_id":"1"}`)
+	activity := []byte(`{"cmd":"SET_ACTIVITY","nonce":"1"}`)
+	// serve answers each of the wanted requests on conn by echoing them.
+	serve := func(conn *fakeConn, want ...[]byte) chan int {
+		done := make(chan int)
+		go func() {
+			for _, payload := range want {
+				buf := <-conn.WriteCh
+				if !bytes.Equal(buf[headerLen:], payload) {
+					t.Errorf("server wanted %s, got %s", payload, buf[headerLen:])
+				}
+				conn.ReadCh <- frame(Frame, string(payload))
+			}
+			done <- 1
+		}()
+		return done
+	}
+
+	wait := func(t *testing.T, done chan int) {
+		select {
+		case <-done:
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for server")
+		}
+	}
+
+	conn := <-conns
+
+	t.Run("Send", func(t *testing.T) {
+		done := serve(conn, handshake, activity)
+		for _, payload := range [][]byte{handshake, activity} {
+			if _, err := client.Send(payload); err != nil {
+				t.Error(err)
+			}
+		}
+		wait(t, done)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		// Simulate Discord restarting.
+		conn.ReadCh <- frame(Close, `{"code":1000,"message":"bye"}`)
+		conn = <-conns
+
+		// The handshake and activity should be replayed before the next payload.
+		next := []byte(`{"cmd":"SET_ACTIVITY","nonce":"2"}`)
+		done := serve(conn, handshake, activity, next)
+		if _, err := client.Send(next); err != nil {
+			t.Error(err)
+		}
+		wait(t, done)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		client.Reset()
+		conn = <-conns
+
+		// Nothing should be replayed before the new handshake.
+		next := []byte(`{"v":1,"client_id":"2"}`)
+		done := serve(conn, next)
+		if _, err := client.Send(next); err != nil {
+			t.Error(err)
+		}
+		wait(t, done)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		client.Close()
+		select {
+		case err := <-startDone:
+			if err != nil {
+				t.Errorf("Start() returned error %v, wanted nil", err)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for Start() to return")
+		}
+		if _, err := client.Send(activity); err == nil {
+			t.Error("wanted Send() to return error after Close()")
+		}
+	})
+}
+
+func TestReconnectingClientDeadline(t *testing.T) {
+	errDial := errors.New("no Discord")
+	client := NewReconnectingClient(ReconnectOptions{
+		Backoff:  Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Deadline: 10 * time.Millisecond,
+		Dial: func(ctx context.Context) (*Client, error) {
+			return nil, errDial
+		},
+	})
+
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- client.Start()
+	}()
+
+	select {
+	case err := <-startDone:
+		if !errors.Is(err, errDial) {
+			t.Errorf("Start() returned error %v, wanted %v", err, errDial)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("Timeout waiting for Start() to give up")
+	}
+
+	if _, err := client.Send([]byte(`{}`)); !errors.Is(err, errDial) {
+		t.Errorf("Send() returned error %v, wanted %v", err, errDial)
+	}
+}
+
+func TestReconnectingClientWaitConnected(t *testing.T) {
+	errDial := errors.New("no Discord")
+	running := make(chan struct{})
+	client := NewReconnectingClient(ReconnectOptions{
+		Backoff:  Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Deadline: -1,
+		Dial: func(ctx context.Context) (*Client, error) {
+			select {
+			case <-running:
+				return newClient(NewFakeConn()), nil
+			default:
+				return nil, errDial
+			}
+		},
+	})
+	go client.Start()
+	defer client.Close()
+
+	t.Run("Pending", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := client.WaitConnected(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("WaitConnected() returned %v, wanted context.DeadlineExceeded", err)
+		}
+		// With a negative deadline, the client keeps trying.
+		if err := client.LastError(); !errors.Is(err, errDial) {
+			t.Errorf("LastError() returned %v, wanted %v", err, errDial)
+		}
+	})
+
+	t.Run("Connected", func(t *testing.T) {
+		close(running)
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutSeconds*time.Second)
+		defer cancel()
+		if err := client.WaitConnected(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.LastError(); err != nil {
+			t.Errorf("LastError() returned %v, wanted nil", err)
+		}
+	})
+}
+
+func TestReconnectingClientFlapping(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	dials := make(chan time.Time, 3)
+	client := NewReconnectingClient(ReconnectOptions{
+		Backoff: Backoff{Initial: delay, Max: delay},
+		Dial: func(ctx context.Context) (*Client, error) {
+			select {
+			case dials <- time.Now():
+			default:
+			}
+			// Discord accepts the connection and closes it straight away.
+			conn := NewFakeConn()
+			go func() {
+				conn.ReadCh <- frame(Close, "{}")
+			}()
+			return newClient(conn), nil
+		},
+	})
+	go client.Start()
+	defer client.Close()
+
+	var prev time.Time
+	for i := 0; i < cap(dials); i++ {
+		select {
+		case dialed := <-dials:
+			if i > 0 && dialed.Sub(prev) < delay {
+				t.Errorf("Redialled after %v, wanted at least %v", dialed.Sub(prev), delay)
+			}
+			prev = dialed
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("Timeout waiting for redial")
+		}
+	}
+}
+
+// newFakeReconnectingClient returns a started ReconnectingClient that never
+// gives up, and a channel that receives each fake connection it dials.
+func newFakeReconnectingClient(t *testing.T) (*ReconnectingClient, chan *fakeConn) {
+	conns := make(chan *fakeConn)
+	client := NewReconnectingClient(ReconnectOptions{
+		Backoff:  Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		Deadline: -1,
+		Dial: func(ctx context.Context) (*Client, error) {
+			conn := NewFakeConn()
+			conns <- conn
+			return newClient(conn), nil
+		},
+	})
+	go client.Start()
+	t.Cleanup(client.Close)
+	return client, conns
+}
+
+// sendAnswered sends the payload, answers it on conn, and checks that the
+// answer is returned.
+func sendAnswered(t *testing.T, client *ReconnectingClient, conn *fakeConn, payload, answer string) {
+	t.Helper()
+	sendDone := make(chan error, 1)
+	go func() {
+		_, err := client.Send([]byte(payload))
+		sendDone <- err
+	}()
+	expectWrite(t, conn, payload)
+	conn.ReadCh <- frame(Frame, answer)
+	if err := <-sendDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectWrite checks that the client writes the payload to conn.
+func expectWrite(t *testing.T, conn *fakeConn, payload string) {
+	t.Helper()
+	select {
+	case buf := <-conn.WriteCh:
+		if got := string(buf[headerLen:]); got != payload {
+			t.Errorf("server wanted %s, got %s", payload, got)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatalf("Timeout waiting for %s", payload)
+	}
+}
+
+func TestReconnectingClientRejectedReplay(t *testing.T) {
+	client, conns := newFakeReconnectingClient(t)
+
+	const handshake = `{"v":1,"client_id":"1"}`
+	conn := <-conns
+	sendAnswered(t, client, conn, handshake, `{"evt":"READY"}`)
+
+	// Discord restarts, and rejects the replayed handshake.
+	conn.ReadCh <- frame(Close, `{"code":1000}`)
+	conn = <-conns
+	expectWrite(t, conn, handshake)
+	conn.ReadCh <- frame(Close, `{"code":4000,"message":"Invalid Client ID"}`)
+
+	// The handshake shouldn't be replayed on the next connection, and the next
+	// Send() should get the rejection instead of being sent as the handshake.
+	conn = <-conns
+	_, err := client.Send([]byte(`{"cmd":"SET_ACTIVITY","nonce":"1"}`))
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseInvalidClientId {
+		t.Errorf("Send() returned %v, wanted *CloseError with code %d", err, CloseInvalidClientId)
+	}
+	if client.HasHandshake() {
+		t.Error("HasHandshake() returned true after the handshake was rejected")
+	}
+}
+
+func TestReconnectingClientTransientReplay(t *testing.T) {
+	client, conns := newFakeReconnectingClient(t)
+
+	const (
+		handshake = `{"v":1,"client_id":"1"}`
+		activity  = `{"cmd":"SET_ACTIVITY","nonce":"1"}`
+	)
+	conn := <-conns
+	sendAnswered(t, client, conn, handshake, `{"evt":"READY"}`)
+	sendAnswered(t, client, conn, activity, activity)
+
+	// Discord restarts, and rate-limits the replayed handshake.
+	conn.ReadCh <- frame(Close, `{"code":1000}`)
+	conn = <-conns
+	expectWrite(t, conn, handshake)
+	conn.ReadCh <- frame(Close, `{"code":4002,"message":"Rate limited"}`)
+
+	// The handshake and activity should still be replayed next time.
+	conn = <-conns
+	next := `{"cmd":"SET_ACTIVITY","nonce":"2"}`
+	sendDone := make(chan error, 1)
+	go func() {
+		_, err := client.Send([]byte(next))
+		sendDone <- err
+	}()
+	for _, payload := range []string{handshake, activity, next} {
+		expectWrite(t, conn, payload)
+		conn.ReadCh <- frame(Frame, payload)
+	}
+	if err := <-sendDone; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconnectingClientRejectedActivity(t *testing.T) {
+	client, conns := newFakeReconnectingClient(t)
+
+	const handshake = `{"v":1,"client_id":"1"}`
+	conn := <-conns
+	sendAnswered(t, client, conn, handshake, `{"evt":"READY"}`)
+	sendAnswered(t, client, conn, `{"cmd":"SET_ACTIVITY","nonce":"1"}`,
+		`{"cmd":"SET_ACTIVITY","evt":"ERROR","nonce":"1","data":{"code":4000}}`)
+
+	// Discord restarts; only the handshake should be replayed.
+	conn.ReadCh <- frame(Close, `{"code":1000}`)
+	conn = <-conns
+	next := `{"cmd":"SET_ACTIVITY","nonce":"2"}`
+	sendDone := make(chan error, 1)
+	go func() {
+		_, err := client.Send([]byte(next))
+		sendDone <- err
+	}()
+	for _, payload := range []string{handshake, next} {
+		expectWrite(t, conn, payload)
+		conn.ReadCh <- frame(Frame, payload)
+	}
+	if err := <-sendDone; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,76 @@
+package rpc
+
+// Activity types.  Over IPC, Discord only honours ActivityGame.
+const (
+	ActivityGame      = 0
+	ActivityStreaming = 1
+	ActivityListening = 2
+	ActivityWatching  = 3
+	ActivityCustom    = 4
+	ActivityCompeting = 5
+)
+
+// Activity represents a Discord activity.
+// https://discord.com/developers/docs/topics/gateway#activity-object
+// can be used as a guide, but not all fields and values are supported.
+type Activity struct {
+	Type       int         `json:"type,omitempty"`
+	State      string      `json:"state,omitempty"`
+	Details    string      `json:"details,omitempty"`
+	Timestamps *Timestamps `json:"timestamps,omitempty"`
+	Assets     *Assets     `json:"assets,omitempty"`
+	Party      *Party      `json:"party,omitempty"`
+	Secrets    *Secrets    `json:"secrets,omitempty"`
+	Buttons    []Button    `json:"buttons,omitempty"`
+	// Instance is true if the activity is an instanced game session.
+	Instance bool `json:"instance,omitempty"`
+}
+
+// Timestamps are the Unix times (in milliseconds) at which the activity
+// started and will end.
+type Timestamps struct {
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+}
+
+// Assets are the images (and their hover text) for the activity.  The image
+// names are asset keys from the Discord developer portal, or URLs.
+type Assets struct {
+	LargeImage string `json:"large_image,omitempty"`
+	LargeText  string `json:"large_text,omitempty"`
+	SmallImage string `json:"small_image,omitempty"`
+	SmallText  string `json:"small_text,omitempty"`
+}
+
+// Party describes the user's party.  Size is the current and maximum size.
+type Party struct {
+	Id   string `json:"id,omitempty"`
+	Size []int  `json:"size,omitempty"`
+}
+
+// Secrets are used for joining and spectating.
+type Secrets struct {
+	Join     string `json:"join,omitempty"`
+	Spectate string `json:"spectate,omitempty"`
+	Match    string `json:"match,omitempty"`
+}
+
+// Button is a custom button shown with the activity.  Discord allows at most
+// two.
+type Button struct {
+	Label string `json:"label"`
+	Url   string `json:"url"`
+}
+
+// SetActivityArgs represents the "args" in a Discord SET_ACTIVITY request.
+// Documented at:
+// https://github.com/discord/discord-rpc/blob/master/documentation/hard-mode.md
+//
+// The description at:
+// https://discord.com/developers/docs/topics/rpc#setactivity
+// can also be used as a guide, but various fields from the websocket API are
+// not supported and the values have different limitations.
+type SetActivityArgs struct {
+	Pid      int       `json:"pid"`
+	Activity *Activity `json:"activity"`
+}
@@ -0,0 +1,208 @@
+// Package rpc is a typed interface to the commands supported by Discord's IPC.
+//
+// The commands are documented (loosely) at:
+// https://discord.com/developers/docs/topics/rpc#commands-and-events
+//
+// Over IPC, only a subset of the RPC commands are available without OAuth2
+// authorization; this package supports those.  Nonces are generated
+// automatically, and answers with an "ERROR" event are returned as *Error.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+import "github.com/p00ya/chrome-discord-bridge/internal/discord"
+
+// Sender sends a payload to Discord and returns its answer.  It is
+// implemented by *discord.Client and *discord.ReconnectingClient.
+type Sender interface {
+	SendContext(ctx context.Context, payload discord.Payload) (discord.Payload, error)
+}
+
+// Client sends typed commands to Discord.
+type Client struct {
+	sender Sender
+}
+
+// NewClient returns a Client that sends commands with the given Sender.
+func NewClient(sender Sender) *Client {
+	return &Client{sender: sender}
+}
+
+// Discord RPC commands.
+const (
+	CmdDispatch               = "DISPATCH"
+	CmdSetActivity            = "SET_ACTIVITY"
+	CmdSendActivityJoinInvite = "SEND_ACTIVITY_JOIN_INVITE"
+	CmdCloseActivityRequest   = "CLOSE_ACTIVITY_REQUEST"
+	CmdSubscribe              = "SUBSCRIBE"
+	CmdUnsubscribe            = "UNSUBSCRIBE"
+)
+
+// Discord RPC events.
+const (
+	EvtReady               = "READY"
+	EvtError               = "ERROR"
+	EvtActivityJoin        = "ACTIVITY_JOIN"
+	EvtActivitySpectate    = "ACTIVITY_SPECTATE"
+	EvtActivityJoinRequest = "ACTIVITY_JOIN_REQUEST"
+)
+
+// Request contains the generic outer fields for Discord JSON requests.
+type Request struct {
+	Cmd   string      `json:"cmd"`
+	Args  interface{} `json:"args"`
+	Evt   string      `json:"evt,omitempty"`
+	Nonce string      `json:"nonce"`
+}
+
+// Response contains the generic outer fields for Discord JSON answers and
+// events.
+type Response struct {
+	Cmd   string          `json:"cmd"`
+	Evt   string          `json:"evt"`
+	Nonce string          `json:"nonce"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Error is an error reported by Discord, i.e. an answer with an "ERROR" event.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Discord error %d: %s", e.Code, e.Message)
+}
+
+// HandshakeRequest represents the Discord handshake JSON.
+// It doesn't appear to be officially documented.
+type HandshakeRequest struct {
+	// Undocumented API version, 1 is the only known value.
+	Version int `json:"v"`
+	// Application ID from the Discord developer portal.
+	ClientId string `json:"client_id"`
+}
+
+// Ready is the data in the READY event that Discord sends in answer to a
+// handshake.
+type Ready struct {
+	Version int    `json:"v"`
+	Config  Config `json:"config"`
+	User    User   `json:"user"`
+}
+
+// Config describes the Discord environment.
+type Config = discord.Config
+
+// User is a Discord user.
+type User = discord.User
+
+// Handshake sends a handshake for the given application ID.  It must be the
+// first command sent on a connection.
+func (c *Client) Handshake(ctx context.Context, clientId string) (*Ready, error) {
+	request := HandshakeRequest{Version: 1, ClientId: clientId}
+	var ready Ready
+	if err := c.send(ctx, request, &ready); err != nil {
+		return nil, err
+	}
+	return &ready, nil
+}
+
+// Do sends a command with the given arguments, and decodes the data in the
+// answer into result (unless result is nil).
+func (c *Client) Do(ctx context.Context, cmd string, args interface{}, result interface{}) error {
+	return c.send(ctx, Request{Cmd: cmd, Args: args, Nonce: discord.NewNonce()}, result)
+}
+
+// SetActivity sets the user's activity for the process with the given PID.
+// A nil activity clears it.  It returns the activity as Discord accepted it.
+func (c *Client) SetActivity(ctx context.Context, pid int, activity *Activity) (*Activity, error) {
+	args := SetActivityArgs{Pid: pid, Activity: activity}
+	var accepted *Activity
+	if err := c.Do(ctx, CmdSetActivity, args, &accepted); err != nil {
+		return nil, err
+	}
+	return accepted, nil
+}
+
+// SendActivityJoinInvite accepts an ACTIVITY_JOIN_REQUEST from the given user.
+func (c *Client) SendActivityJoinInvite(ctx context.Context, userId string) error {
+	return c.Do(ctx, CmdSendActivityJoinInvite, userArgs{userId}, nil)
+}
+
+// CloseActivityRequest rejects an ACTIVITY_JOIN_REQUEST from the given user.
+func (c *Client) CloseActivityRequest(ctx context.Context, userId string) error {
+	return c.Do(ctx, CmdCloseActivityRequest, userArgs{userId}, nil)
+}
+
+// Subscribe asks Discord to send DISPATCH frames for the named event.  args
+// may be nil if the event takes no arguments.
+func (c *Client) Subscribe(ctx context.Context, evt string, args interface{}) error {
+	return c.sendEvt(ctx, CmdSubscribe, evt, args)
+}
+
+// Unsubscribe asks Discord to stop sending DISPATCH frames for the named
+// event.
+func (c *Client) Unsubscribe(ctx context.Context, evt string, args interface{}) error {
+	return c.sendEvt(ctx, CmdUnsubscribe, evt, args)
+}
+
+func (c *Client) sendEvt(ctx context.Context, cmd, evt string, args interface{}) error {
+	if args == nil {
+		args = struct{}{}
+	}
+	return c.send(ctx, Request{Cmd: cmd, Args: args, Evt: evt, Nonce: discord.NewNonce()}, nil)
+}
+
+// userArgs are the arguments for commands that act on a user.
+type userArgs struct {
+	UserId string `json:"user_id"`
+}
+
+// send encodes the request, sends it and decodes the answer's data into
+// result (unless result is nil).
+func (c *Client) send(ctx context.Context, request interface{}, result interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	answer, err := c.sender.SendContext(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := ParseResponse(answer)
+	if err != nil {
+		return err
+	}
+	if result == nil || len(response.Data) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(response.Data, result); err != nil {
+		return fmt.Errorf("decoding %s answer: %w", response.Cmd, err)
+	}
+	return nil
+}
+
+// ParseResponse decodes an answer or event from Discord.  If it has an
+// "ERROR" event, the returned error will be an *Error.
+func ParseResponse(payload discord.Payload) (*Response, error) {
+	var response Response
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return nil, fmt.Errorf("decoding answer: %w", err)
+	}
+
+	if response.Evt == EvtError {
+		var e Error
+		if err := json.Unmarshal(response.Data, &e); err != nil {
+			return nil, fmt.Errorf("decoding error: %w", err)
+		}
+		return &response, &e
+	}
+	return &response, nil
+}
@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+import "github.com/p00ya/chrome-discord-bridge/internal/discord"
+
+// senderFunc adapts a function to the Sender interface.
+type senderFunc func(payload discord.Payload) (discord.Payload, error)
+
+func (f senderFunc) SendContext(ctx context.Context, payload discord.Payload) (discord.Payload, error) {
+	return f(payload)
+}
+
+func TestHandshake(t *testing.T) {
+	client := NewClient(senderFunc(func(payload discord.Payload) (discord.Payload, error) {
+		want := `{"v":1,"client_id":"123"}`
+		if string(payload) != want {
+			t.Errorf("wanted request %s, got %s", want, payload)
+		}
+		return []byte(`{"cmd":"DISPATCH","evt":"READY","nonce":null,"data":{"v":1,` +
+			`"config":{"api_endpoint":"//discord.com/api"},"user":{"id":"42","username":"alice"}}}`), nil
+	}))
+
+	ready, err := client.Handshake(context.Background(), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.User.Id != "42" || ready.Config.ApiEndpoint != "//discord.com/api" {
+		t.Errorf("got unexpected READY data %+v", ready)
+	}
+}
+
+func TestSetActivity(t *testing.T) {
+	var nonces []string
+	client := NewClient(senderFunc(func(payload discord.Payload) (discord.Payload, error) {
+		var req struct {
+			Cmd   string          `json:"cmd"`
+			Nonce string          `json:"nonce"`
+			Args  SetActivityArgs `json:"args"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Cmd != CmdSetActivity || req.Args.Pid != 7 {
+			t.Errorf("got unexpected request %s", payload)
+		}
+		nonces = append(nonces, req.Nonce)
+
+		data, _ := json.Marshal(req.Args.Activity)
+		return []byte(`{"cmd":"SET_ACTIVITY","nonce":"` + req.Nonce + `","data":` + string(data) + `}`), nil
+	}))
+
+	activity := &Activity{
+		State:   "state",
+		Buttons: []Button{{Label: "Join", Url: "https://example.com/"}},
+	}
+	for i := 0; i < 2; i++ {
+		accepted, err := client.SetActivity(context.Background(), 7, activity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted.State != "state" || len(accepted.Buttons) != 1 {
+			t.Errorf("got unexpected activity %+v", accepted)
+		}
+	}
+
+	if len(nonces) != 2 || nonces[0] == nonces[1] {
+		t.Errorf("wanted distinct nonces, got %v", nonces)
+	}
+}
+
+func TestError(t *testing.T) {
+	client := NewClient(senderFunc(func(payload discord.Payload) (discord.Payload, error) {
+		return []byte(`{"cmd":"SUBSCRIBE","evt":"ERROR","nonce":"1",` +
+			`"data":{"code":4006,"message":"Not authenticated or invalid scope"}}`), nil
+	}))
+
+	err := client.Subscribe(context.Background(), EvtActivityJoin, nil)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("wanted *Error, got %v", err)
+	}
+	if rpcErr.Code != 4006 || rpcErr.Message != "Not authenticated or invalid scope" {
+		t.Errorf("got unexpected error %+v", rpcErr)
+	}
+}